@@ -0,0 +1,223 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedactedValue is the placeholder of the credentials redacted by the library.
+const RedactedValue = "REDACTED"
+
+// Authenticator interface defines the request authenticator.
+// The authenticator is applied to each attempt of the request after the request headers
+// are merged, so it can refresh its credentials at any time.
+type Authenticator interface {
+	// Authenticate adds the credentials to the given HTTP request.
+	Authenticate(*http.Request) error
+}
+
+// AuthenticatorFunc type is an adapter to allow the use of ordinary functions as authenticators.
+type AuthenticatorFunc func(*http.Request) error
+
+// Authenticate adds the credentials to the given HTTP request.
+func (f AuthenticatorFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// The redactor interface is implemented by the authenticators that need to remove their
+// credentials from the errors produced by the library.
+type redactor interface {
+	redact(error) error
+}
+
+// The challenger interface is implemented by the authenticators that handle the
+// authentication challenge of the server. The challenge method is called with each
+// response and reports whether the request should be authenticated and sent again.
+type challenger interface {
+	challenge(*http.Request, *http.Response) bool
+}
+
+// NewBasicAuthenticator returns an authenticator that uses the HTTP Basic authentication.
+func NewBasicAuthenticator(username, password string) Authenticator {
+	return &basicAuthenticator{username: username, password: password}
+}
+
+// The basicAuthenticator type is a built-in implementation of the HTTP Basic authentication.
+type basicAuthenticator struct {
+	username string
+	password string
+}
+
+// Authenticate adds the credentials to the given HTTP request.
+func (a *basicAuthenticator) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+// String returns the redacted description of the authenticator.
+func (a *basicAuthenticator) String() string {
+	return "Basic " + a.username + ":" + RedactedValue
+}
+
+// NewBearerAuthenticator returns an authenticator that uses the given static bearer token.
+func NewBearerAuthenticator(token string) Authenticator {
+	return &bearerAuthenticator{token: token}
+}
+
+// The bearerAuthenticator type is a built-in implementation of the static bearer token authentication.
+type bearerAuthenticator struct {
+	token string
+}
+
+// Authenticate adds the credentials to the given HTTP request.
+func (a *bearerAuthenticator) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// String returns the redacted description of the authenticator.
+func (a *bearerAuthenticator) String() string {
+	return "Bearer " + RedactedValue
+}
+
+// NewHeaderAPIKeyAuthenticator returns an authenticator that sends the given API key in the
+// given request header.
+func NewHeaderAPIKeyAuthenticator(header, key string) Authenticator {
+	return &apiKeyAuthenticator{name: header, key: key}
+}
+
+// NewQueryAPIKeyAuthenticator returns an authenticator that sends the given API key in the
+// given query parameter.
+func NewQueryAPIKeyAuthenticator(param, key string) Authenticator {
+	return &apiKeyAuthenticator{name: param, key: key, query: true}
+}
+
+// The apiKeyAuthenticator type is a built-in implementation of the API key authentication.
+type apiKeyAuthenticator struct {
+	name  string
+	key   string
+	query bool
+}
+
+// Authenticate adds the credentials to the given HTTP request.
+func (a *apiKeyAuthenticator) Authenticate(req *http.Request) error {
+	if !a.query {
+		req.Header.Set(a.name, a.key)
+		return nil
+	}
+	qs := req.URL.Query()
+	qs.Set(a.name, a.key)
+	req.URL.RawQuery = qs.Encode()
+	return nil
+}
+
+// String returns the redacted description of the authenticator.
+func (a *apiKeyAuthenticator) String() string {
+	if a.query {
+		return "APIKey query " + a.name + "=" + RedactedValue
+	}
+	return "APIKey header " + a.name + ": " + RedactedValue
+}
+
+// The redact method removes the API key from the URL of the given error.
+func (a *apiKeyAuthenticator) redact(err error) error {
+	if !a.query {
+		return err
+	}
+	var e *url.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	u, perr := url.Parse(e.URL)
+	if perr != nil || u.RawQuery == "" {
+		return err
+	}
+	qs := u.Query()
+	if _, found := qs[a.name]; !found {
+		return err
+	}
+	qs.Set(a.name, RedactedValue)
+	u.RawQuery = qs.Encode()
+	return &url.Error{Op: e.Op, URL: u.String(), Err: e.Err}
+}
+
+// The authMiddleware function returns the middleware that applies the given authenticator
+// to the request, and removes the credentials from the returned error.
+// If the authenticator handles the authentication challenge, the request is sent again
+// once when the challenge requires it and the request body is replayable.
+func authMiddleware(a Authenticator) Middleware {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			c, ok := a.(challenger)
+			if !ok {
+				return authenticate(a, req, next)
+			}
+			// Keep a copy of the original request to send it again.
+			orig := req.Clone(req.Context())
+			o, err := authenticate(a, req, next)
+			if err != nil || !c.challenge(req, o) || !isReplayableRequest(orig) {
+				return o, err
+			}
+			drainResponseBody(o)
+			return sendAttempt(orig, func(req *http.Request) (*http.Response, error) {
+				return authenticate(a, req, next)
+			})
+		}
+	}
+}
+
+// The authenticate function authenticates the given request and sends it.
+func authenticate(a Authenticator, req *http.Request, next Handler) (*http.Response, error) {
+	if err := a.Authenticate(req); err != nil {
+		return nil, err
+	}
+	o, err := next(req)
+	if err != nil {
+		return nil, redactError(a, err)
+	}
+	return o, nil
+}
+
+// The redactError function removes the credentials of the given authenticator from the given error.
+func redactError(a Authenticator, err error) error {
+	if r, ok := a.(redactor); ok {
+		return r.redact(err)
+	}
+	return err
+}
+
+// RedactHeaders returns a copy of the given headers with the credentials redacted.
+// The "Authorization", "Proxy-Authorization", "Cookie" and "Set-Cookie" headers and the
+// given extra headers are redacted, the authentication scheme of the authorization headers
+// is preserved. This function is useful for dumping requests and responses.
+func RedactHeaders(h http.Header, extra ...string) http.Header {
+	r := h.Clone()
+	for _, key := range append([]string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}, extra...) {
+		key = http.CanonicalHeaderKey(key)
+		values := r[key]
+		for i := range values {
+			if (key == "Authorization" || key == "Proxy-Authorization") && strings.Contains(values[i], " ") {
+				values[i] = values[i][:strings.IndexByte(values[i], ' ')] + " " + RedactedValue
+			} else {
+				values[i] = RedactedValue
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Authorization")+"|"+r.Header.Get("X-API-Key")+"|"+r.URL.RawQuery)
+	}))
+	defer server.Close()
+
+	c := New()
+	if c.SetAuthenticator(NewBasicAuthenticator("foo", "bar")) == nil {
+		t.Fatal("Client.SetAuthenticator() return nil")
+	}
+	// The authenticator is applied after the common headers are merged.
+	c.SetCommonHeader("Authorization", "common")
+
+	items := []struct {
+		Give func(Request) Request
+		Want string
+	}{
+		{func(r Request) Request { return r }, "Basic Zm9vOmJhcg==||a=1"},
+		{func(r Request) Request { return r.WithAuthenticator(NewBearerAuthenticator("token")) }, "Bearer token||a=1"},
+		{func(r Request) Request { return r.WithAuthenticator(NewHeaderAPIKeyAuthenticator("X-API-Key", "key")) }, "common|key|a=1"},
+		{func(r Request) Request { return r.WithAuthenticator(NewQueryAPIKeyAuthenticator("key", "k")) }, "common||a=1&key=k"},
+		{func(r Request) Request { return r.WithoutAuthenticator() }, "common||a=1"},
+		{func(r Request) Request { return r.WithoutAuthenticator().WithAuthenticator(nil) }, "Basic Zm9vOmJhcg==||a=1"},
+	}
+	for i, item := range items {
+		req := item.Give(c.New(server.URL).WithQuery("a", "1"))
+		if req == nil {
+			t.Fatalf("[%d] Request returned nil", i)
+		}
+		res, err := req.Get()
+		if err != nil {
+			t.Fatalf("[%d] Request.Get() error: %s", i, err)
+		}
+		if got := res.String(); got != item.Want {
+			t.Fatalf("[%d] Request.Get() want %q got %q", i, item.Want, got)
+		}
+	}
+
+	c.SetAuthenticator(AuthenticatorFunc(func(req *http.Request) error {
+		return errors.New("auth error")
+	}))
+	if _, err := c.Get(server.URL, nil); err == nil || err.Error() != "auth error" {
+		t.Fatalf("Client.Get() return error: %v", err)
+	}
+}
+
+func TestAuthenticator_Redact(t *testing.T) {
+	for _, a := range []Authenticator{
+		NewBasicAuthenticator("foo", "secret"),
+		NewBearerAuthenticator("secret"),
+		NewHeaderAPIKeyAuthenticator("X-API-Key", "secret"),
+		NewQueryAPIKeyAuthenticator("key", "secret"),
+	} {
+		if s := fmt.Sprint(a); strings.Contains(s, "secret") || !strings.Contains(s, RedactedValue) {
+			t.Fatalf("Authenticator string: %s", s)
+		}
+	}
+
+	c := New().SetAuthenticator(NewQueryAPIKeyAuthenticator("key", "secret"))
+	_, err := c.Get("http://127.0.0.1:1/test", nil)
+	if err == nil {
+		t.Fatal("Client.Get() return nil error")
+	}
+	if s := err.Error(); strings.Contains(s, "secret") || !strings.Contains(s, "key="+RedactedValue) {
+		t.Fatalf("Client.Get() return error: %s", s)
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"a=secret"},
+		"X-Api-Key":     {"secret"},
+		"X-Other":       {"value"},
+	}
+	r := RedactHeaders(h, "x-api-key")
+	if got := r.Get("Authorization"); got != "Bearer "+RedactedValue {
+		t.Fatalf("RedactHeaders() Authorization: %s", got)
+	}
+	if got := r.Get("Cookie"); got != RedactedValue {
+		t.Fatalf("RedactHeaders() Cookie: %s", got)
+	}
+	if got := r.Get("X-Api-Key"); got != RedactedValue {
+		t.Fatalf("RedactHeaders() X-Api-Key: %s", got)
+	}
+	if got := r.Get("X-Other"); got != "value" {
+		t.Fatalf("RedactHeaders() X-Other: %s", got)
+	}
+	if got := h.Get("Authorization"); got != "Bearer secret" {
+		t.Fatalf("RedactHeaders() modified the given headers: %s", got)
+	}
+}
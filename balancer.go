@@ -0,0 +1,472 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/edoger/zkits-requester/internal"
+)
+
+var (
+	// ErrInvalidLoadBalancer is returned when the load balancer options are invalid.
+	ErrInvalidLoadBalancer = errors.New("invalid load balancer")
+
+	// ErrNoAvailableEndpoint represents a no available endpoint error.
+	// When sending a request, this error is returned if all endpoints of the load balancer
+	// are ejected or unhealthy.
+	ErrNoAvailableEndpoint = errors.New("no available endpoint")
+)
+
+// Default values of the LoadBalancerOptions and HealthCheckOptions fields.
+const (
+	DefaultLoadBalancerMaxFailures  = 3
+	DefaultLoadBalancerEjectionTime = 30 * time.Second
+	DefaultHealthCheckInterval      = 10 * time.Second
+	DefaultHealthCheckTimeout       = 5 * time.Second
+)
+
+// LoadBalanceStrategy represents the endpoint selection strategy of the load balancer.
+type LoadBalanceStrategy int
+
+// These are the endpoint selection strategies.
+const (
+	// RoundRobin selects the endpoints in turn, the weights are ignored.
+	RoundRobin LoadBalanceStrategy = iota
+	// LeastInFlight selects the endpoint with the fewest in-flight requests.
+	LeastInFlight
+	// WeightedRandom selects a random endpoint with the probability of its weight.
+	WeightedRandom
+	// ConsistentHash selects the endpoint by the hash of the request key, so that the
+	// requests with the same key are sent to the same endpoint while it is available.
+	ConsistentHash
+)
+
+// String returns the name of the current strategy.
+func (s LoadBalanceStrategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "round-robin"
+	case LeastInFlight:
+		return "least-in-flight"
+	case WeightedRandom:
+		return "weighted-random"
+	case ConsistentHash:
+		return "consistent-hash"
+	}
+	return fmt.Sprintf("LoadBalanceStrategy(%d)", int(s))
+}
+
+// LoadBalancerEndpoint defines an endpoint of the load balancer.
+type LoadBalancerEndpoint struct {
+	// URL is the base URL of the endpoint, such as "http://10.0.0.1:8080/api".
+	URL string
+
+	// Weight is the weight of the endpoint for the WeightedRandom and ConsistentHash
+	// strategies. If it is zero, 1 is used.
+	Weight int
+}
+
+// LoadBalancerOptions defines the client-side load balancer of the client.
+type LoadBalancerOptions struct {
+	// Endpoints is the endpoints of the load balancer, at least one is required.
+	Endpoints []LoadBalancerEndpoint
+
+	// Strategy is the endpoint selection strategy, RoundRobin by default.
+	Strategy LoadBalanceStrategy
+
+	// HashKey returns the key of the request for the ConsistentHash strategy.
+	// If it is nil, the path of the request url is used.
+	HashKey func(*http.Request) string
+
+	// MaxFailures is the number of consecutive failures that ejects the endpoint.
+	// If it is zero, DefaultLoadBalancerMaxFailures is used, and the negative value
+	// disables the passive ejection.
+	MaxFailures int
+
+	// EjectionTime is the period the ejected endpoint is not selected.
+	// If it is zero, DefaultLoadBalancerEjectionTime is used.
+	EjectionTime time.Duration
+
+	// IsFailure reports whether the result of a request sent to the endpoint is a failure.
+	// If it is nil, transport errors (except context cancellation) and responses with
+	// status 5xx are failures. The errors of the client before the request is sent, such
+	// as ErrRateLimited and the authenticator errors, and the cancelled requests are
+	// neither failures nor successes.
+	IsFailure func(*http.Response, error) bool
+
+	// HealthCheck enables the active health probes of the endpoints.
+	// If it is nil, the endpoints are always considered healthy.
+	HealthCheck *HealthCheckOptions
+}
+
+// HealthCheckOptions defines the active health probes of the load balancer endpoints.
+// The probes are scheduled by the requests of the client, so an idle client sends no
+// probes. The probes are sent by the HTTP client of the client without the middlewares.
+type HealthCheckOptions struct {
+	// Path is the path of the probe requests, resolved against the endpoint URL
+	// like the request uris, such as "/healthz".
+	Path string
+
+	// Interval is the interval between the probes of an endpoint.
+	// If it is zero, DefaultHealthCheckInterval is used.
+	Interval time.Duration
+
+	// Timeout is the timeout of the probe requests.
+	// If it is zero, DefaultHealthCheckTimeout is used.
+	Timeout time.Duration
+
+	// IsHealthy reports whether the probe response is healthy.
+	// If it is nil, the responses with status 2xx are healthy.
+	IsHealthy func(*http.Response) bool
+}
+
+// LoadBalancerEndpointStats represents the current statistics of a load balancer endpoint.
+type LoadBalancerEndpointStats struct {
+	// URL is the base URL of the endpoint.
+	URL string
+
+	// InFlight is the number of in-flight requests of the endpoint.
+	InFlight int
+
+	// Failures is the number of consecutive failures of the endpoint.
+	Failures int
+
+	// Ejected indicates whether the endpoint is ejected by the failures.
+	Ejected bool
+
+	// Healthy indicates whether the last health probe of the endpoint is healthy.
+	Healthy bool
+}
+
+// The loadBalancerVirtualNodes constant is the number of the hash ring nodes of each
+// weight unit of the endpoints.
+const loadBalancerVirtualNodes = 100
+
+// The loadBalancer type is the client-side load balancer of the client.
+type loadBalancer struct {
+	options   LoadBalancerOptions
+	err       error
+	endpoints []*balancerEndpoint
+	ring      []balancerRingNode
+	mutex     sync.Mutex
+	next      int
+}
+
+// The balancerEndpoint type holds the state of a single endpoint.
+type balancerEndpoint struct {
+	url      string
+	weight   int
+	inFlight int
+	failures int
+	ejected  time.Time
+	healthy  bool
+	checked  time.Time
+	checking bool
+}
+
+// The balancerRingNode type is a node of the consistent hash ring.
+type balancerRingNode struct {
+	hash     uint32
+	endpoint *balancerEndpoint
+}
+
+// The newLoadBalancer function creates a load balancer from the given options.
+// If the options are invalid, the load balancer returns the error for every request.
+func newLoadBalancer(options *LoadBalancerOptions) *loadBalancer {
+	b := &loadBalancer{options: *options}
+	if b.options.MaxFailures == 0 {
+		b.options.MaxFailures = DefaultLoadBalancerMaxFailures
+	}
+	if b.options.EjectionTime <= 0 {
+		b.options.EjectionTime = DefaultLoadBalancerEjectionTime
+	}
+	if options.HealthCheck != nil {
+		check := *options.HealthCheck
+		if check.Interval <= 0 {
+			check.Interval = DefaultHealthCheckInterval
+		}
+		if check.Timeout <= 0 {
+			check.Timeout = DefaultHealthCheckTimeout
+		}
+		b.options.HealthCheck = &check
+	}
+
+	if len(options.Endpoints) == 0 {
+		b.err = fmt.Errorf("%w: no endpoint", ErrInvalidLoadBalancer)
+		return b
+	}
+	if options.Strategy < RoundRobin || options.Strategy > ConsistentHash {
+		b.err = fmt.Errorf("%w: unsupported strategy %s", ErrInvalidLoadBalancer, options.Strategy)
+		return b
+	}
+	for _, endpoint := range options.Endpoints {
+		u, err := url.Parse(endpoint.URL)
+		if err != nil {
+			b.err = fmt.Errorf("%w: %s", ErrInvalidLoadBalancer, err)
+			return b
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			b.err = fmt.Errorf("%w: invalid endpoint url %q", ErrInvalidLoadBalancer, endpoint.URL)
+			return b
+		}
+		if endpoint.Weight < 0 {
+			b.err = fmt.Errorf("%w: negative weight of %q", ErrInvalidLoadBalancer, endpoint.URL)
+			return b
+		}
+		e := &balancerEndpoint{url: endpoint.URL, weight: endpoint.Weight, healthy: true}
+		if e.weight == 0 {
+			e.weight = 1
+		}
+		b.endpoints = append(b.endpoints, e)
+	}
+
+	if b.options.Strategy == ConsistentHash {
+		for i, e := range b.endpoints {
+			for j, n := 0, e.weight*loadBalancerVirtualNodes; j < n; j++ {
+				// The index is a part of the node key, so that the duplicate endpoints
+				// have different nodes.
+				key := strconv.Itoa(i) + "#" + strconv.Itoa(j) + "#" + e.url
+				b.ring = append(b.ring, balancerRingNode{hash: crc32.ChecksumIEEE([]byte(key)), endpoint: e})
+			}
+		}
+		sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+	}
+	return b
+}
+
+// The isRelativeURI function determines whether the given request uri is resolved against
+// the endpoints of the load balancer.
+func isRelativeURI(uri string) bool {
+	ref, err := url.Parse(uri)
+	return err == nil && ref.Scheme == "" && ref.Host == ""
+}
+
+// The base method returns the base URL used to build the request before an endpoint is
+// selected, it is the URL of the first endpoint without the query parameters, which are
+// merged when an endpoint is selected.
+func (b *loadBalancer) base() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	u, err := url.Parse(b.endpoints[0].url)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// The stats method returns the current statistics of the endpoints.
+func (b *loadBalancer) stats() []LoadBalancerEndpointStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	stats := make([]LoadBalancerEndpointStats, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		stats = append(stats, LoadBalancerEndpointStats{
+			URL:      e.url,
+			InFlight: e.inFlight,
+			Failures: e.failures,
+			Ejected:  now.Before(e.ejected),
+			Healthy:  e.healthy,
+		})
+	}
+	return stats
+}
+
+// The middleware method returns the middleware that sends each attempt of the request
+// to an endpoint selected by the load balancer. The given uri is the relative request
+// uri, and the given sender sends the health probes.
+// The in-flight request of the endpoint is counted until the response body is closed.
+func (b *loadBalancer) middleware(next Handler, uri string, sender Handler) Handler {
+	return func(req *http.Request) (*http.Response, error) {
+		if b.options.HealthCheck != nil {
+			b.schedule(sender)
+		}
+		e, err := b.pick(req)
+		if err != nil {
+			return nil, err
+		}
+		var once sync.Once
+		release := func() { once.Do(func() { b.release(e) }) }
+
+		u, err := internal.ResolveURL(e.url, uri)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		// The query parameters of the request replace the query parameters of the endpoint.
+		u.RawQuery, u.Fragment = internal.MergeRawQuery(u.RawQuery, req.URL.RawQuery), req.URL.Fragment
+		copied := req.WithContext(req.Context())
+		if req.Host == req.URL.Host {
+			copied.Host = u.Host
+		}
+		copied.URL = u
+
+		copied, sent := withSentFlag(copied)
+		o, err := next(copied)
+		if !isNeutralResult(sent, err) {
+			b.done(e, isHostFailure(b.options.IsFailure, o, err))
+		}
+		if err != nil || o.Body == nil {
+			release()
+			return o, err
+		}
+		o.Body = &releaseBody{ReadCloser: o.Body, release: release}
+		return o, nil
+	}
+}
+
+// The available method determines whether the given endpoint can be selected.
+func (e *balancerEndpoint) available(now time.Time) bool {
+	return e.healthy && !now.Before(e.ejected)
+}
+
+// The pick method selects an endpoint for the given request by the strategy, and counts
+// an in-flight request of the selected endpoint.
+func (b *loadBalancer) pick(req *http.Request) (*balancerEndpoint, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	available := make([]*balancerEndpoint, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if e.available(now) {
+			available = append(available, e)
+		}
+	}
+	if len(available) == 0 {
+		return nil, ErrNoAvailableEndpoint
+	}
+
+	var e *balancerEndpoint
+	switch b.options.Strategy {
+	case RoundRobin:
+		e = available[b.next%len(available)]
+		b.next++
+	case LeastInFlight:
+		// The scan starts from a rotating offset, so that the ties are broken in turn.
+		offset := b.next % len(available)
+		b.next++
+		for i := range available {
+			if c := available[(offset+i)%len(available)]; e == nil || c.inFlight < e.inFlight {
+				e = c
+			}
+		}
+	case WeightedRandom:
+		var total int
+		for _, c := range available {
+			total += c.weight
+		}
+		n := rand.Intn(total)
+		for _, c := range available {
+			if n -= c.weight; n < 0 {
+				e = c
+				break
+			}
+		}
+	case ConsistentHash:
+		key := req.URL.Path
+		if b.options.HashKey != nil {
+			key = b.options.HashKey(req)
+		}
+		hash := crc32.ChecksumIEEE([]byte(key))
+		i := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= hash })
+		// The unavailable endpoints are skipped clockwise.
+		for j := range b.ring {
+			if c := b.ring[(i+j)%len(b.ring)].endpoint; c.available(now) {
+				e = c
+				break
+			}
+		}
+	}
+	e.inFlight++
+	return e, nil
+}
+
+// The release method releases an in-flight request of the given endpoint.
+func (b *loadBalancer) release(e *balancerEndpoint) {
+	b.mutex.Lock()
+	e.inFlight--
+	b.mutex.Unlock()
+}
+
+// The done method records the result of a request to the given endpoint, and ejects the
+// endpoint if it fails too many times in a row.
+func (b *loadBalancer) done(e *balancerEndpoint, failure bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !failure {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	if b.options.MaxFailures > 0 && e.failures >= b.options.MaxFailures {
+		e.failures = 0
+		e.ejected = time.Now().Add(b.options.EjectionTime)
+	}
+}
+
+// The schedule method starts the health probes of the endpoints that are due.
+func (b *loadBalancer) schedule(sender Handler) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	for _, e := range b.endpoints {
+		if !e.checking && now.Sub(e.checked) >= b.options.HealthCheck.Interval {
+			e.checking = true
+			go b.check(e, sender)
+		}
+	}
+}
+
+// The check method sends a health probe to the given endpoint and records the result.
+func (b *loadBalancer) check(e *balancerEndpoint, sender Handler) {
+	options := b.options.HealthCheck
+	healthy := false
+	if u, err := internal.ResolveURL(e.url, options.Path); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+		defer cancel()
+
+		if req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil); err == nil {
+			if o, err := sender(req); err == nil {
+				if options.IsHealthy != nil {
+					healthy = options.IsHealthy(o)
+				} else {
+					healthy = o.StatusCode >= 200 && o.StatusCode < 300
+				}
+				drainResponseBody(o)
+			}
+		}
+	}
+
+	b.mutex.Lock()
+	e.healthy, e.checked, e.checking = healthy, time.Now(), false
+	b.mutex.Unlock()
+}
@@ -0,0 +1,382 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The newBalancerServers function creates the test servers that respond with their names.
+func newBalancerServers(names ...string) ([]LoadBalancerEndpoint, func()) {
+	var endpoints []LoadBalancerEndpoint
+	var servers []*httptest.Server
+	for _, name := range names {
+		name := name
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name+" "+r.URL.RequestURI())
+		}))
+		servers = append(servers, s)
+		endpoints = append(endpoints, LoadBalancerEndpoint{URL: s.URL})
+	}
+	return endpoints, func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+}
+
+// The balancerHit function sends the given request and returns the name of the server
+// that responded.
+func balancerHit(t *testing.T, r Request) string {
+	res, err := r.Get()
+	if err != nil {
+		t.Fatalf("Request.Get(): %s", err)
+	}
+	return strings.SplitN(res.String(), " ", 2)[0]
+}
+
+func TestClient_SetLoadBalancer(t *testing.T) {
+	endpoints, done := newBalancerServers("a", "b", "c")
+	defer done()
+
+	c := New().SetLoadBalancer(&LoadBalancerOptions{Endpoints: endpoints})
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, balancerHit(t, c.New("/")))
+	}
+	if s := strings.Join(got, ","); s != "a,b,c,a,b,c" {
+		t.Fatalf("Client.SetLoadBalancer(): %s", s)
+	}
+
+	// The absolute request uris are not balanced.
+	res, err := c.New(endpoints[2].URL + "/x").Get()
+	if err != nil {
+		t.Fatalf("Request.Get(): %s", err)
+	}
+	if got := res.String(); got != "c /x" {
+		t.Fatalf("Client.SetLoadBalancer(): %s", got)
+	}
+	if stats := c.LoadBalancerStats(); len(stats) != 3 || stats[0].URL != endpoints[0].URL || stats[0].InFlight != 0 {
+		t.Fatalf("Client.LoadBalancerStats(): %+v", stats)
+	}
+
+	if c.SetLoadBalancer(nil).LoadBalancerStats() != nil {
+		t.Fatal("Client.LoadBalancerStats(): not nil")
+	}
+	if _, err := c.New("").Get(); !errors.Is(err, ErrEmptyRequestURL) {
+		t.Fatalf("Request.Get(): %v", err)
+	}
+}
+
+func TestClient_SetLoadBalancer_URL(t *testing.T) {
+	endpoints, done := newBalancerServers("a")
+	defer done()
+
+	endpoints[0].URL += "/api"
+	c := New().SetLoadBalancer(&LoadBalancerOptions{Endpoints: endpoints}).SetCommonQuery("c", "1")
+	res, err := c.New("/v1/{id}?a=1").WithPathParam("id", 7).WithQuery("b", "2").Get()
+	if err != nil {
+		t.Fatalf("Request.Get(): %s", err)
+	}
+	if got := res.String(); got != "a /api/v1/7?a=1&b=2&c=1" {
+		t.Fatalf("Client.SetLoadBalancer(): %s", got)
+	}
+	if res, err = c.New("").Get(); err != nil {
+		t.Fatalf("Request.Get(): %s", err)
+	}
+	if got := res.String(); got != "a /api?c=1" {
+		t.Fatalf("Client.SetLoadBalancer(): %s", got)
+	}
+
+	// The query parameters of each endpoint are only sent to the endpoint.
+	more, done2 := newBalancerServers("b")
+	defer done2()
+	endpoints[0].URL += "?key=a&x=1"
+	more[0].URL += "?key=b"
+	c = New().SetLoadBalancer(&LoadBalancerOptions{Endpoints: append(endpoints, more...)})
+	items := []struct {
+		Query string
+		Want  string
+	}{
+		{"", "a /api/v1?a=1&key=a&x=1"},
+		{"", "b /v1?a=1&key=b"},
+		{"2", "a /api/v1?a=1&key=a&x=2"},
+	}
+	for _, item := range items {
+		r := c.New("/v1?a=1")
+		if item.Query != "" {
+			r.WithQuery("x", item.Query)
+		}
+		if res, err = r.Get(); err != nil {
+			t.Fatalf("Request.Get(): %s", err)
+		}
+		if got, want := res.String(), item.Want; got != want {
+			t.Fatalf("Client.SetLoadBalancer(): want %s got %s", want, got)
+		}
+	}
+}
+
+func TestClient_SetLoadBalancer_Invalid(t *testing.T) {
+	items := []*LoadBalancerOptions{
+		{},
+		{Endpoints: []LoadBalancerEndpoint{{URL: "ftp://example.com"}}},
+		{Endpoints: []LoadBalancerEndpoint{{URL: "example.com"}}},
+		{Endpoints: []LoadBalancerEndpoint{{URL: "http://example.com", Weight: -1}}},
+		{Endpoints: []LoadBalancerEndpoint{{URL: "http://example.com"}}, Strategy: LoadBalanceStrategy(9)},
+	}
+	for i, item := range items {
+		_, err := New().SetLoadBalancer(item).New("/").Get()
+		if !errors.Is(err, ErrInvalidLoadBalancer) {
+			t.Fatalf("Client.SetLoadBalancer(): %d %v", i, err)
+		}
+	}
+}
+
+func TestClient_SetLoadBalancer_Ejection(t *testing.T) {
+	endpoints, done := newBalancerServers("b")
+	defer done()
+
+	var failures int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failures, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	endpoints = append([]LoadBalancerEndpoint{{URL: failing.URL}}, endpoints...)
+	c := New().SetLoadBalancer(&LoadBalancerOptions{Endpoints: endpoints, MaxFailures: 2, EjectionTime: time.Minute})
+	for i := 0; i < 10; i++ {
+		_, _ = c.New("/").Get()
+	}
+	if got := atomic.LoadInt32(&failures); got != 2 {
+		t.Fatalf("Client.SetLoadBalancer(): failures %d", got)
+	}
+	if stats := c.LoadBalancerStats(); !stats[0].Ejected || stats[1].Ejected {
+		t.Fatalf("Client.LoadBalancerStats(): %+v", stats)
+	}
+
+	// The retry is sent to another endpoint.
+	c = New().SetLoadBalancer(&LoadBalancerOptions{Endpoints: endpoints, MaxFailures: -1})
+	res, err := c.New("/").WithRetry(&RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond}).Get()
+	if err != nil {
+		t.Fatalf("Request.Get(): %s", err)
+	}
+	if got := res.String(); got != "b /" {
+		t.Fatalf("Client.SetLoadBalancer(): %s", got)
+	}
+
+	// All endpoints are ejected.
+	c = New().SetLoadBalancer(&LoadBalancerOptions{Endpoints: endpoints[:1], MaxFailures: 1})
+	_, _ = c.New("/").Get()
+	if _, err = c.New("/").Get(); !errors.Is(err, ErrNoAvailableEndpoint) {
+		t.Fatalf("Request.Get(): %v", err)
+	}
+}
+
+func TestClient_SetLoadBalancer_LocalErrors(t *testing.T) {
+	endpoints, done := newBalancerServers("a")
+	defer done()
+
+	// The errors of the client before the request is sent are not failures of the endpoint
+	// or the host.
+	c := New().SetLoadBalancer(&LoadBalancerOptions{Endpoints: endpoints, MaxFailures: 1}).
+		SetCircuitBreaker(&CircuitBreakerOptions{ConsecutiveFailures: 1}).
+		SetRateLimiter(&RateLimiterOptions{Global: &RateLimit{Rate: 1}, Mode: RateLimitFail})
+	if got := balancerHit(t, c.New("/")); got != "a" {
+		t.Fatalf("Client.SetLoadBalancer(): %s", got)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.New("/").Get(); !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("Request.Get(): %v", err)
+		}
+	}
+	if stats := c.LoadBalancerStats(); stats[0].Ejected || stats[0].Failures != 0 {
+		t.Fatalf("Client.LoadBalancerStats(): %+v", stats)
+	}
+
+	c.SetRateLimiter(nil)
+	failure := errors.New("middleware error")
+	_, err := c.New("/").WithMiddleware(func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) { return nil, failure }
+	}).Get()
+	if !errors.Is(err, failure) {
+		t.Fatalf("Request.Get(): %v", err)
+	}
+	if got := balancerHit(t, c.New("/")); got != "a" {
+		t.Fatalf("Client.SetLoadBalancer(): %s", got)
+	}
+}
+
+func TestClient_SetLoadBalancer_Cancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// The cancelled requests do not reset the failures of the endpoint.
+	c := New().SetLoadBalancer(&LoadBalancerOptions{
+		Endpoints: []LoadBalancerEndpoint{{URL: server.URL}}, MaxFailures: 2, EjectionTime: time.Minute,
+	})
+	_, _ = c.New("/").Get()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := c.New("/slow").WithContext(ctx).Get(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Request.Get(): %v", err)
+	}
+	_, _ = c.New("/").Get()
+	if stats := c.LoadBalancerStats(); !stats[0].Ejected {
+		t.Fatalf("Client.LoadBalancerStats(): %+v", stats)
+	}
+}
+
+func TestClient_SetLoadBalancer_LeastInFlight(t *testing.T) {
+	endpoints, done := newBalancerServers("b")
+	defer done()
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = io.WriteString(w, "a "+r.URL.RequestURI())
+	}))
+	defer slow.Close()
+
+	endpoints = append([]LoadBalancerEndpoint{{URL: slow.URL}}, endpoints...)
+	c := New().SetLoadBalancer(&LoadBalancerOptions{Endpoints: endpoints, Strategy: LeastInFlight})
+	result := make(chan string, 1)
+	go func() {
+		res, err := c.New("/").Get()
+		if err != nil {
+			result <- err.Error()
+		} else {
+			result <- res.String()
+		}
+	}()
+	for c.LoadBalancerStats()[0].InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		if got := balancerHit(t, c.New("/")); got != "b" {
+			t.Fatalf("Client.SetLoadBalancer(): %s", got)
+		}
+	}
+	close(release)
+	if got := <-result; got != "a /" {
+		t.Fatalf("Client.SetLoadBalancer(): %s", got)
+	}
+}
+
+func TestClient_SetLoadBalancer_WeightedRandom(t *testing.T) {
+	endpoints, done := newBalancerServers("a", "b")
+	defer done()
+
+	endpoints[1].Weight = 3
+	c := New().SetLoadBalancer(&LoadBalancerOptions{Endpoints: endpoints, Strategy: WeightedRandom})
+	hits := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		hits[balancerHit(t, c.New("/"))]++
+	}
+	if hits["a"] == 0 || hits["b"] <= hits["a"] {
+		t.Fatalf("Client.SetLoadBalancer(): %v", hits)
+	}
+}
+
+func TestClient_SetLoadBalancer_ConsistentHash(t *testing.T) {
+	endpoints, done := newBalancerServers("a", "b", "c")
+	defer done()
+
+	c := New().SetLoadBalancer(&LoadBalancerOptions{
+		Endpoints: endpoints,
+		Strategy:  ConsistentHash,
+		HashKey:   func(req *http.Request) string { return req.Header.Get("X-Key") },
+	})
+	hits := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		key := string(rune('a' + i))
+		want := balancerHit(t, c.New("/").WithHeader("X-Key", key))
+		for j := 0; j < 3; j++ {
+			if got := balancerHit(t, c.New("/").WithHeader("X-Key", key)); got != want {
+				t.Fatalf("Client.SetLoadBalancer(): %s %s != %s", key, got, want)
+			}
+		}
+		hits[want] = true
+	}
+	if len(hits) < 2 {
+		t.Fatalf("Client.SetLoadBalancer(): %v", hits)
+	}
+}
+
+func TestClient_SetLoadBalancer_HealthCheck(t *testing.T) {
+	endpoints, done := newBalancerServers("b")
+	defer done()
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "a "+r.URL.RequestURI())
+	}))
+	defer unhealthy.Close()
+
+	endpoints = append([]LoadBalancerEndpoint{{URL: unhealthy.URL}}, endpoints...)
+	c := New().SetLoadBalancer(&LoadBalancerOptions{
+		Endpoints:   endpoints,
+		HealthCheck: &HealthCheckOptions{Path: "/healthz", Interval: time.Hour},
+	})
+	// The first request schedules the probes.
+	_, _ = c.New("/").Get()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.LoadBalancerStats()[0].Healthy {
+		if time.Now().After(deadline) {
+			t.Fatal("Client.SetLoadBalancer(): the endpoint is healthy")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		if got := balancerHit(t, c.New("/")); got != "b" {
+			t.Fatalf("Client.SetLoadBalancer(): %s", got)
+		}
+	}
+	if !c.LoadBalancerStats()[1].Healthy {
+		t.Fatalf("Client.LoadBalancerStats(): %+v", c.LoadBalancerStats())
+	}
+}
+
+func TestLoadBalanceStrategy_String(t *testing.T) {
+	items := map[LoadBalanceStrategy]string{
+		RoundRobin:             "round-robin",
+		LeastInFlight:          "least-in-flight",
+		WeightedRandom:         "weighted-random",
+		ConsistentHash:         "consistent-hash",
+		LoadBalanceStrategy(9): "LoadBalanceStrategy(9)",
+	}
+	for s, want := range items {
+		if got := s.String(); got != want {
+			t.Fatalf("LoadBalanceStrategy.String(): %s", got)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen represents an open circuit breaker error.
+// When sending a request, this error is returned if the circuit breaker of the target host
+// is open, or it is half-open and the probe limit is reached.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Default values of the CircuitBreakerOptions fields.
+const (
+	DefaultCircuitBreakerCoolDown            = 30 * time.Second
+	DefaultCircuitBreakerMinRequests         = 10
+	DefaultCircuitBreakerConsecutiveFailures = 5
+)
+
+// CircuitBreakerState represents the state of a circuit breaker.
+type CircuitBreakerState int
+
+// These are the states of a circuit breaker.
+const (
+	// CircuitClosed state lets all requests pass and counts failures.
+	CircuitClosed CircuitBreakerState = iota
+	// CircuitOpen state rejects all requests until the cool-down period expires.
+	CircuitOpen
+	// CircuitHalfOpen state lets a limited number of probe requests pass.
+	CircuitHalfOpen
+)
+
+// String returns the name of the current state.
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitBreakerState(%d)", int(s))
+}
+
+// CircuitBreakerOptions defines the per-host circuit breaker of the client.
+type CircuitBreakerOptions struct {
+	// ConsecutiveFailures is the number of consecutive failures that opens the circuit.
+	// Zero disables this threshold, however, if FailureRate is also zero,
+	// DefaultCircuitBreakerConsecutiveFailures is used.
+	ConsecutiveFailures int
+
+	// FailureRate is the failure rate, in the range (0, 1], that opens the circuit once at
+	// least MinRequests requests have been counted. Zero disables this threshold.
+	FailureRate float64
+
+	// MinRequests is the minimum number of requests before the failure rate is evaluated.
+	// If it is zero, DefaultCircuitBreakerMinRequests is used.
+	MinRequests int
+
+	// Interval is the period after which the counters of a closed circuit are cleared.
+	// If it is zero, the counters are only cleared when the circuit state changes.
+	Interval time.Duration
+
+	// CoolDown is the period an open circuit waits before it becomes half-open.
+	// If it is zero, DefaultCircuitBreakerCoolDown is used.
+	CoolDown time.Duration
+
+	// HalfOpenProbes is the maximum number of concurrent probe requests in the half-open
+	// state, and also the number of successful probes that close the circuit.
+	// If it is zero, a single probe is used.
+	HalfOpenProbes int
+
+	// IsFailure reports whether the result of a request sent to the host is a failure.
+	// If it is nil, transport errors (except context cancellation) and responses with
+	// status 5xx are failures. The errors of the client before the request is sent, such
+	// as ErrRateLimited and the authenticator errors, and the cancelled requests are
+	// neither failures nor successes.
+	IsFailure func(*http.Response, error) bool
+
+	// OnStateChange is called after the circuit state of a host changes.
+	OnStateChange func(host string, from, to CircuitBreakerState)
+}
+
+// The circuitBreaker type manages the circuits of all hosts.
+type circuitBreaker struct {
+	options CircuitBreakerOptions
+	mutex   sync.Mutex
+	hosts   map[string]*circuit
+}
+
+// The circuit type holds the state of a single host.
+type circuit struct {
+	state       CircuitBreakerState
+	generation  uint64
+	expiry      time.Time
+	requests    int
+	failures    int
+	consecutive int
+	probes      int
+	successes   int
+}
+
+// The newCircuitBreaker function creates a circuit breaker from the given options.
+func newCircuitBreaker(options *CircuitBreakerOptions) *circuitBreaker {
+	b := &circuitBreaker{options: *options, hosts: make(map[string]*circuit)}
+	if b.options.ConsecutiveFailures <= 0 && b.options.FailureRate <= 0 {
+		// Without any threshold, the circuit would never open.
+		b.options.ConsecutiveFailures = DefaultCircuitBreakerConsecutiveFailures
+	}
+	if b.options.MinRequests <= 0 {
+		b.options.MinRequests = DefaultCircuitBreakerMinRequests
+	}
+	if b.options.CoolDown <= 0 {
+		b.options.CoolDown = DefaultCircuitBreakerCoolDown
+	}
+	if b.options.HalfOpenProbes <= 0 {
+		b.options.HalfOpenProbes = 1
+	}
+	return b
+}
+
+// The middleware method returns the middleware that guards requests with the circuit
+// of the target host.
+func (b *circuitBreaker) middleware(next Handler) Handler {
+	return func(req *http.Request) (*http.Response, error) {
+		host := req.URL.Host
+		generation, err := b.allow(host)
+		if err != nil {
+			return nil, err
+		}
+		req, sent := withSentFlag(req)
+		o, err := next(req)
+		if isNeutralResult(sent, err) {
+			b.cancel(host, generation)
+			return o, err
+		}
+		b.done(host, generation, isHostFailure(b.options.IsFailure, o, err))
+		return o, err
+	}
+}
+
+// The allow method checks whether a request to the given host can be sent, and returns
+// the current circuit generation.
+func (b *circuitBreaker) allow(host string) (uint64, error) {
+	b.mutex.Lock()
+	c := b.hosts[host]
+	if c == nil {
+		c = &circuit{}
+		if b.options.Interval > 0 {
+			c.expiry = time.Now().Add(b.options.Interval)
+		}
+		b.hosts[host] = c
+	}
+	from, to := b.refresh(c, time.Now())
+
+	var err error
+	switch c.state {
+	case CircuitOpen:
+		err = fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+	case CircuitHalfOpen:
+		if c.probes >= b.options.HalfOpenProbes {
+			err = fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+		} else {
+			c.probes++
+		}
+	}
+	generation := c.generation
+	b.mutex.Unlock()
+
+	b.notify(host, from, to)
+	return generation, err
+}
+
+// The cancel method releases the probe slot of a request to the given host without
+// recording its result, which is used for the requests that are not sent or cancelled.
+func (b *circuitBreaker) cancel(host string, generation uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if c := b.hosts[host]; c.generation == generation && c.state == CircuitHalfOpen {
+		c.probes--
+	}
+}
+
+// The done method records the result of a request to the given host.
+func (b *circuitBreaker) done(host string, generation uint64, failure bool) {
+	b.mutex.Lock()
+	c := b.hosts[host]
+	from, to := b.refresh(c, time.Now())
+	if c.generation != generation {
+		// The result belongs to an earlier state, ignore it.
+		b.mutex.Unlock()
+		b.notify(host, from, to)
+		return
+	}
+
+	switch c.state {
+	case CircuitClosed:
+		c.requests++
+		if failure {
+			c.failures++
+			c.consecutive++
+		} else {
+			c.consecutive = 0
+		}
+		if b.shouldOpen(c) {
+			from, to = b.setState(c, CircuitOpen, time.Now())
+		}
+	case CircuitHalfOpen:
+		c.probes--
+		if failure {
+			from, to = b.setState(c, CircuitOpen, time.Now())
+		} else {
+			c.successes++
+			if c.successes >= b.options.HalfOpenProbes {
+				from, to = b.setState(c, CircuitClosed, time.Now())
+			}
+		}
+	}
+	b.mutex.Unlock()
+
+	b.notify(host, from, to)
+}
+
+// The shouldOpen method determines whether the given closed circuit should be opened.
+func (b *circuitBreaker) shouldOpen(c *circuit) bool {
+	if b.options.ConsecutiveFailures > 0 && c.consecutive >= b.options.ConsecutiveFailures {
+		return true
+	}
+	if b.options.FailureRate > 0 && c.requests >= b.options.MinRequests {
+		return float64(c.failures)/float64(c.requests) >= b.options.FailureRate
+	}
+	return false
+}
+
+// The refresh method updates the given circuit by the given time, and returns the state
+// transition if any.
+func (b *circuitBreaker) refresh(c *circuit, now time.Time) (CircuitBreakerState, CircuitBreakerState) {
+	switch c.state {
+	case CircuitClosed:
+		if !c.expiry.IsZero() && now.After(c.expiry) {
+			c.generation++
+			c.requests, c.failures, c.consecutive = 0, 0, 0
+			c.expiry = now.Add(b.options.Interval)
+		}
+	case CircuitOpen:
+		if now.After(c.expiry) {
+			return b.setState(c, CircuitHalfOpen, now)
+		}
+	}
+	return c.state, c.state
+}
+
+// The setState method changes the state of the given circuit and clears its counters.
+func (b *circuitBreaker) setState(c *circuit, state CircuitBreakerState, now time.Time) (CircuitBreakerState, CircuitBreakerState) {
+	from := c.state
+	c.state = state
+	c.generation++
+	c.requests, c.failures, c.consecutive, c.probes, c.successes = 0, 0, 0, 0, 0
+	switch state {
+	case CircuitClosed:
+		if b.options.Interval > 0 {
+			c.expiry = now.Add(b.options.Interval)
+		} else {
+			c.expiry = time.Time{}
+		}
+	case CircuitOpen:
+		c.expiry = now.Add(b.options.CoolDown)
+	default:
+		c.expiry = time.Time{}
+	}
+	return from, state
+}
+
+// The notify method calls the state change callback if the state has changed.
+func (b *circuitBreaker) notify(host string, from, to CircuitBreakerState) {
+	if from != to && b.options.OnStateChange != nil {
+		b.options.OnStateChange(host, from, to)
+	}
+}
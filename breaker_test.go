@@ -0,0 +1,187 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerState_String(t *testing.T) {
+	items := map[CircuitBreakerState]string{
+		CircuitClosed:           "closed",
+		CircuitOpen:             "open",
+		CircuitHalfOpen:         "half-open",
+		CircuitBreakerState(10): "CircuitBreakerState(10)",
+	}
+	for state, want := range items {
+		if got := state.String(); got != want {
+			t.Fatalf("CircuitBreakerState.String() want %q got %q", want, got)
+		}
+	}
+}
+
+func TestClient_SetCircuitBreaker(t *testing.T) {
+	var failing int32 = 1
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var transitions []string
+	c := New()
+	options := &CircuitBreakerOptions{
+		ConsecutiveFailures: 2,
+		CoolDown:            20 * time.Millisecond,
+		OnStateChange: func(host string, from, to CircuitBreakerState) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			transitions = append(transitions, from.String()+">"+to.String())
+		},
+	}
+	if c.SetCircuitBreaker(options) == nil {
+		t.Fatal("Client.SetCircuitBreaker() return nil")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(server.URL, nil); err != nil {
+			t.Fatalf("Client.Get() error: %s", err)
+		}
+	}
+	if _, err := c.Get(server.URL, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Client.Get() with open circuit return error: %v", err)
+	}
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Fatalf("Client.Get() sent %d requests", n)
+	}
+
+	// After the cool-down period, a failed probe opens the circuit again.
+	time.Sleep(30 * time.Millisecond)
+	if _, err := c.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	if _, err := c.Get(server.URL, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Client.Get() with open circuit return error: %v", err)
+	}
+
+	// After the cool-down period, a successful probe closes the circuit.
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(server.URL, nil); err != nil {
+			t.Fatalf("Client.Get() error: %s", err)
+		}
+	}
+
+	want := "closed>open,open>half-open,half-open>open,open>half-open,half-open>closed"
+	mutex.Lock()
+	got := strings.Join(transitions, ",")
+	mutex.Unlock()
+	if got != want {
+		t.Fatalf("CircuitBreakerOptions.OnStateChange() want %q got %q", want, got)
+	}
+
+	if c.SetCircuitBreaker(nil) == nil {
+		t.Fatal("Client.SetCircuitBreaker(nil) return nil")
+	}
+}
+
+func TestCircuitBreaker_FailureRate(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerOptions{FailureRate: 0.5, MinRequests: 4, HalfOpenProbes: 2})
+
+	for _, failure := range []bool{true, false, true} {
+		generation, err := b.allow("test")
+		if err != nil {
+			t.Fatalf("circuitBreaker.allow() error: %s", err)
+		}
+		b.done("test", generation, failure)
+	}
+	generation, _ := b.allow("test")
+	b.done("test", generation, false)
+	if _, err := b.allow("test"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("circuitBreaker.allow() return error: %v", err)
+	}
+
+	// Results from the closed state are ignored after the circuit is opened.
+	b.done("test", generation, false)
+	if state := b.hosts["test"].state; state != CircuitOpen {
+		t.Fatalf("circuitBreaker state: %s", state)
+	}
+
+	// The number of concurrent probes is limited in the half-open state.
+	b.hosts["test"].expiry = time.Now().Add(-time.Second)
+	for i := 0; i < 2; i++ {
+		if _, err := b.allow("test"); err != nil {
+			t.Fatalf("circuitBreaker.allow() error: %s", err)
+		}
+	}
+	if _, err := b.allow("test"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("circuitBreaker.allow() return error: %v", err)
+	}
+}
+
+func TestCircuitBreaker_Default(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerOptions{})
+	for i := 0; i < DefaultCircuitBreakerConsecutiveFailures; i++ {
+		generation, err := b.allow("test")
+		if err != nil {
+			t.Fatalf("circuitBreaker.allow() error: %s", err)
+		}
+		b.done("test", generation, true)
+	}
+	if _, err := b.allow("test"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("circuitBreaker.allow() return error: %v", err)
+	}
+}
+
+func TestCircuitBreaker_Cancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// The cancelled requests are neither failures nor successes.
+	c := New().SetCircuitBreaker(&CircuitBreakerOptions{ConsecutiveFailures: 2})
+	if _, err := c.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := c.New(server.URL + "/slow").WithContext(ctx).Get(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Client.Get() return error: %v", err)
+	}
+	if _, err := c.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	if _, err := c.Get(server.URL, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Client.Get() with open circuit return error: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBulkheadFull represents a full bulkhead error.
+// When sending a request, this error is returned if the concurrency limit of the client is
+// reached and the request can not wait in the queue, or the queue timeout expires.
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// BulkheadOptions defines the concurrency limiter of the client.
+type BulkheadOptions struct {
+	// MaxConcurrent is the maximum number of in-flight requests of the client.
+	// Zero means no limit.
+	MaxConcurrent int
+
+	// MaxConcurrentPerHost is the maximum number of in-flight requests to each host.
+	// Zero means no limit.
+	MaxConcurrentPerHost int
+
+	// MaxQueue is the maximum number of requests waiting for an in-flight slot.
+	// Requests exceeding the queue fail immediately with ErrBulkheadFull.
+	MaxQueue int
+
+	// QueueTimeout is the maximum waiting time in the queue.
+	// If it is zero, requests wait until the request context is done.
+	QueueTimeout time.Duration
+}
+
+// BulkheadStats represents the current statistics of the bulkhead.
+type BulkheadStats struct {
+	// InFlight is the number of in-flight requests.
+	InFlight int
+
+	// Queued is the number of requests waiting in the queue.
+	Queued int
+
+	// Hosts is the number of in-flight requests of each host.
+	Hosts map[string]int
+}
+
+// The bulkhead type is the concurrency limiter of the client.
+type bulkhead struct {
+	options  BulkheadOptions
+	global   chan struct{}
+	inFlight int64
+	queued   int64
+	mutex    sync.Mutex
+	hosts    map[string]chan struct{}
+}
+
+// The newBulkhead function creates a bulkhead from the given options.
+func newBulkhead(options *BulkheadOptions) *bulkhead {
+	b := &bulkhead{options: *options, hosts: make(map[string]chan struct{})}
+	if options.MaxConcurrent > 0 {
+		b.global = make(chan struct{}, options.MaxConcurrent)
+	}
+	return b
+}
+
+// The stats method returns the current statistics of the bulkhead.
+func (b *bulkhead) stats() BulkheadStats {
+	stats := BulkheadStats{
+		InFlight: int(atomic.LoadInt64(&b.inFlight)),
+		Queued:   int(atomic.LoadInt64(&b.queued)),
+		Hosts:    make(map[string]int),
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for host, sem := range b.hosts {
+		if n := len(sem); n > 0 {
+			stats.Hosts[host] = n
+		}
+	}
+	return stats
+}
+
+// The middleware method returns the middleware that limits the number of in-flight requests.
+// The in-flight slot is held until the response body is closed.
+func (b *bulkhead) middleware(next Handler) Handler {
+	return func(req *http.Request) (*http.Response, error) {
+		host := req.URL.Host
+		release, err := b.acquire(req.Context(), host)
+		if err != nil {
+			return nil, err
+		}
+		o, err := next(req)
+		if err != nil || o.Body == nil {
+			release()
+			return o, err
+		}
+		o.Body = &releaseBody{ReadCloser: o.Body, release: release}
+		return o, nil
+	}
+}
+
+// The semaphore method returns the semaphore of the given host, or nil if there is no
+// per-host limit.
+func (b *bulkhead) semaphore(host string) chan struct{} {
+	if b.options.MaxConcurrentPerHost <= 0 {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	sem := b.hosts[host]
+	if sem == nil {
+		sem = make(chan struct{}, b.options.MaxConcurrentPerHost)
+		b.hosts[host] = sem
+	}
+	return sem
+}
+
+// The acquire method acquires an in-flight slot for the given host, and returns the
+// function that releases it.
+func (b *bulkhead) acquire(ctx context.Context, host string) (func(), error) {
+	sems := []chan struct{}{b.semaphore(host), b.global}
+	acquired := make([]chan struct{}, 0, 2)
+	release := func() {
+		for _, sem := range acquired {
+			<-sem
+		}
+	}
+
+	var queued bool
+	var timeout <-chan time.Time
+	defer func() {
+		if queued {
+			atomic.AddInt64(&b.queued, -1)
+		}
+	}()
+
+	for _, sem := range sems {
+		if sem == nil {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+			acquired = append(acquired, sem)
+			continue
+		default:
+		}
+
+		if !queued {
+			if atomic.AddInt64(&b.queued, 1) > int64(b.options.MaxQueue) {
+				atomic.AddInt64(&b.queued, -1)
+				release()
+				return nil, fmt.Errorf("%w: %s", ErrBulkheadFull, host)
+			}
+			queued = true
+			if b.options.QueueTimeout > 0 {
+				timer := time.NewTimer(b.options.QueueTimeout)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+		}
+
+		select {
+		case sem <- struct{}{}:
+			acquired = append(acquired, sem)
+		case <-timeout:
+			release()
+			return nil, fmt.Errorf("%w: %s: queue timeout", ErrBulkheadFull, host)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	atomic.AddInt64(&b.inFlight, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&b.inFlight, -1)
+			release()
+		})
+	}, nil
+}
+
+// The releaseBody type releases the in-flight slot when the response body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close closes the response body and releases the in-flight slot.
+func (b *releaseBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_SetBulkhead(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-block
+	}))
+	defer server.Close()
+
+	c := New()
+	if got := c.BulkheadStats(); got.InFlight != 0 || got.Queued != 0 {
+		t.Fatalf("Client.BulkheadStats() got %+v", got)
+	}
+	options := &BulkheadOptions{MaxConcurrent: 2, MaxConcurrentPerHost: 1, MaxQueue: 1, QueueTimeout: time.Minute}
+	if c.SetBulkhead(options) == nil {
+		t.Fatal("Client.SetBulkhead() return nil")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(server.URL, nil); err != nil {
+				t.Errorf("Client.Get() error: %s", err)
+			}
+		}()
+	}
+	<-started
+	// Wait for the second request to enter the queue.
+	for i := 0; i < 100 && c.BulkheadStats().Queued == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := c.BulkheadStats()
+	if stats.InFlight != 1 || stats.Queued != 1 || len(stats.Hosts) != 1 {
+		t.Fatalf("Client.BulkheadStats() got %+v", stats)
+	}
+	if _, err := c.Get(server.URL, nil); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("Client.Get() with full queue return error: %v", err)
+	}
+
+	close(block)
+	wg.Wait()
+	if stats := c.BulkheadStats(); stats.InFlight != 0 || stats.Queued != 0 || len(stats.Hosts) != 0 {
+		t.Fatalf("Client.BulkheadStats() got %+v", stats)
+	}
+
+	if c.SetBulkhead(nil) == nil {
+		t.Fatal("Client.SetBulkhead(nil) return nil")
+	}
+}
+
+func TestBulkhead_QueueTimeout(t *testing.T) {
+	b := newBulkhead(&BulkheadOptions{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 10 * time.Millisecond})
+	req := httptest.NewRequest(http.MethodGet, "http://test.com", nil)
+
+	release, err := b.acquire(req.Context(), "test.com")
+	if err != nil {
+		t.Fatalf("bulkhead.acquire() error: %s", err)
+	}
+	if _, err := b.acquire(req.Context(), "test.com"); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("bulkhead.acquire() return error: %v", err)
+	}
+	release()
+	release()
+	if stats := b.stats(); stats.InFlight != 0 || stats.Queued != 0 {
+		t.Fatalf("bulkhead.stats() got %+v", stats)
+	}
+	if release, err := b.acquire(req.Context(), "test.com"); err != nil {
+		t.Fatalf("bulkhead.acquire() error: %s", err)
+	} else {
+		release()
+	}
+}
+
+func TestBulkhead_CircuitBreaker(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			started <- struct{}{}
+			<-block
+		}
+	}))
+	defer server.Close()
+
+	c := New().SetBulkhead(&BulkheadOptions{MaxConcurrent: 1}).
+		SetCircuitBreaker(&CircuitBreakerOptions{ConsecutiveFailures: 3})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Get(server.URL+"/block", nil)
+		done <- err
+	}()
+	<-started
+
+	// The rejections of the bulkhead are not failures of the host.
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(server.URL, nil); !errors.Is(err, ErrBulkheadFull) {
+			t.Fatalf("Client.Get() return error: %v", err)
+		}
+	}
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	if _, err := c.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCertificateReloadInterval is the default interval of checking the certificate files.
+const DefaultCertificateReloadInterval = time.Minute
+
+// CertificateReloaderOptions defines the client certificate reloader.
+type CertificateReloaderOptions struct {
+	// CertFile and KeyFile are the PEM encoded certificate and private key files.
+	CertFile string
+	KeyFile  string
+
+	// Interval is the interval of checking the modification of the files.
+	// If it is zero, DefaultCertificateReloadInterval is used.
+	Interval time.Duration
+
+	// OnError is called when the modified files can not be loaded, the last successfully
+	// loaded certificate is kept in use. It is called from the watching goroutine.
+	OnError func(error)
+}
+
+// CertificateReloader interface defines the client certificate source that reloads the
+// certificate when the files are modified.
+// The reloaded certificate is used by the new TLS connections, the established connections
+// keep the certificate they were created with.
+type CertificateReloader interface {
+	// GetClientCertificate returns the current certificate, it can be used as the
+	// tls.Config.GetClientCertificate function.
+	GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+	// Certificate returns the current certificate.
+	Certificate() *tls.Certificate
+
+	// Reload checks the files immediately and reloads the certificate if they are modified.
+	Reload() error
+
+	// Close stops watching the files.
+	Close()
+}
+
+// NewCertificateReloader loads the certificate from the given files, and returns a reloader
+// that checks the files periodically. The files are checked by polling their modification
+// time and size, so that it works on all platforms and file systems.
+func NewCertificateReloader(options *CertificateReloaderOptions) (CertificateReloader, error) {
+	r := &certificateReloader{options: *options, done: make(chan struct{})}
+	if r.options.Interval <= 0 {
+		r.options.Interval = DefaultCertificateReloadInterval
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+// The certificateReloader type is a built-in implementation of the CertificateReloader interface.
+type certificateReloader struct {
+	options CertificateReloaderOptions
+	current atomic.Value
+	mutex   sync.Mutex
+	stamp   string
+	done    chan struct{}
+	once    sync.Once
+}
+
+// GetClientCertificate returns the current certificate, it can be used as the
+// tls.Config.GetClientCertificate function.
+func (r *certificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// Certificate returns the current certificate.
+func (r *certificateReloader) Certificate() *tls.Certificate {
+	return r.current.Load().(*tls.Certificate)
+}
+
+// Reload checks the files immediately and reloads the certificate if they are modified.
+func (r *certificateReloader) Reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return err
+	}
+	if stamp == r.stamp {
+		return nil
+	}
+	// The files may be written one by one, the stamp is updated even if the loading fails,
+	// so that the loading is retried when the files are modified again.
+	r.stamp = stamp
+	cert, err := tls.LoadX509KeyPair(r.options.CertFile, r.options.KeyFile)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTLSOptions, err)
+	}
+	r.current.Store(&cert)
+	return nil
+}
+
+// Close stops watching the files.
+func (r *certificateReloader) Close() {
+	r.once.Do(func() { close(r.done) })
+}
+
+// The fileStamp method returns the modification stamp of the files.
+func (r *certificateReloader) fileStamp() (string, error) {
+	var stamp string
+	for _, name := range []string{r.options.CertFile, r.options.KeyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrInvalidTLSOptions, err)
+		}
+		stamp += fmt.Sprintf("%d:%d;", fi.ModTime().UnixNano(), fi.Size())
+	}
+	return stamp, nil
+}
+
+// The watch method checks the files periodically until the reloader is closed.
+func (r *certificateReloader) watch() {
+	ticker := time.NewTicker(r.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil && r.options.OnError != nil {
+				r.options.OnError(err)
+			}
+		}
+	}
+}
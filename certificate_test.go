@@ -0,0 +1,157 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The touchFiles function changes the modification time of the given files, so that the
+// modification is detected regardless of the time resolution of the file system.
+func touchFiles(t *testing.T, pair TLSKeyPair, d time.Duration) {
+	now := time.Now().Add(d)
+	for _, name := range []string{pair.CertFile, pair.KeyFile} {
+		if err := os.Chtimes(name, now, now); err != nil {
+			t.Fatalf("os.Chtimes(): %s", err)
+		}
+	}
+}
+
+func TestNewCertificateReloader(t *testing.T) {
+	dir, clean := newTestTempDir(t)
+	defer clean()
+
+	ca := newTestCert(t, "ca", nil, false)
+	server := newTestCert(t, "server.test", ca, false)
+	caFiles := ca.writeFiles(t, dir, "ca")
+	pair := newTestCert(t, "client-a", ca, true).writeFiles(t, dir, "client")
+
+	s := newTestTLSServer(t, ca, server)
+	s.Config.SetKeepAlivesEnabled(false)
+	defer s.Close()
+
+	r, err := NewCertificateReloader(&CertificateReloaderOptions{CertFile: pair.CertFile, KeyFile: pair.KeyFile})
+	if err != nil {
+		t.Fatalf("NewCertificateReloader(): %s", err)
+	}
+	defer r.Close()
+
+	config, err := NewTLSConfig(&TLSOptions{
+		RootCAFiles:               []string{caFiles.CertFile},
+		ClientCertificates:        []TLSKeyPair{{CertFile: "ignored", KeyFile: "ignored"}},
+		ClientCertificateReloader: r,
+	})
+	if err != nil {
+		t.Fatalf("NewTLSConfig(): %s", err)
+	}
+	c := New().SetTLSConfig(config)
+
+	res, err := c.New(s.URL).Get()
+	if err != nil {
+		t.Fatalf("Client.Get(): %s", err)
+	}
+	if got := res.String(); got != "client-a" {
+		t.Fatalf("CertificateReloader: %s", got)
+	}
+
+	// The files are not modified.
+	old := r.Certificate()
+	if err := r.Reload(); err != nil {
+		t.Fatalf("CertificateReloader.Reload(): %s", err)
+	}
+	if r.Certificate() != old {
+		t.Fatal("CertificateReloader.Reload(): certificate changed")
+	}
+
+	newTestCert(t, "client-b", ca, true).writeFiles(t, dir, "client")
+	touchFiles(t, pair, time.Second)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("CertificateReloader.Reload(): %s", err)
+	}
+	if res, err = c.New(s.URL).Get(); err != nil {
+		t.Fatalf("Client.Get(): %s", err)
+	}
+	if got := res.String(); got != "client-b" {
+		t.Fatalf("CertificateReloader: %s", got)
+	}
+	r.Close()
+	r.Close()
+}
+
+func TestNewCertificateReloader_Watch(t *testing.T) {
+	dir, clean := newTestTempDir(t)
+	defer clean()
+
+	ca := newTestCert(t, "ca", nil, false)
+	pair := newTestCert(t, "client-a", ca, true).writeFiles(t, dir, "client")
+
+	errs := make(chan error, 10)
+	r, err := NewCertificateReloader(&CertificateReloaderOptions{
+		CertFile: pair.CertFile,
+		KeyFile:  pair.KeyFile,
+		Interval: 10 * time.Millisecond,
+		OnError:  func(err error) { errs <- err },
+	})
+	if err != nil {
+		t.Fatalf("NewCertificateReloader(): %s", err)
+	}
+	defer r.Close()
+	good := r.Certificate()
+
+	// The broken files are reported and the last good certificate is kept.
+	if err := ioutil.WriteFile(pair.CertFile, []byte("broken"), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile(): %s", err)
+	}
+	touchFiles(t, pair, time.Second)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrInvalidTLSOptions) {
+			t.Fatalf("CertificateReloaderOptions.OnError(): %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CertificateReloaderOptions.OnError(): timeout")
+	}
+	if cert, _ := r.GetClientCertificate(nil); cert != good {
+		t.Fatal("CertificateReloader.GetClientCertificate(): certificate changed")
+	}
+
+	newTestCert(t, "client-b", ca, true).writeFiles(t, dir, "client")
+	touchFiles(t, pair, 2*time.Second)
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Certificate() == good {
+		if time.Now().After(deadline) {
+			t.Fatal("CertificateReloader: timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewCertificateReloader_Error(t *testing.T) {
+	dir, clean := newTestTempDir(t)
+	defer clean()
+
+	_, err := NewCertificateReloader(&CertificateReloaderOptions{
+		CertFile: filepath.Join(dir, "missing.crt"),
+		KeyFile:  filepath.Join(dir, "missing.key"),
+	})
+	if !errors.Is(err, ErrInvalidTLSOptions) {
+		t.Fatalf("NewCertificateReloader(): %v", err)
+	}
+}
@@ -15,9 +15,11 @@
 package requester
 
 import (
+	"crypto/tls"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,7 +37,8 @@ type Client interface {
 	// This timeout period can be overridden by each request timeout setting.
 	SetTimeout(time.Duration) Client
 
-	// GetCommonHeaders returns the common request headers of the current client.
+	// GetCommonHeaders returns a copy of the common request headers of the current client.
+	// Modifying the returned headers does not affect the client, use the setters instead.
 	GetCommonHeaders() http.Header
 
 	// SetCommonHeader sets a common request header of the current client.
@@ -47,9 +50,136 @@ type Client interface {
 	// If nil is given, all common request headers will be deleted.
 	SetCommonHeaders(http.Header) Client
 
+	// GetCommonQueries returns a copy of the common query parameters of the current client.
+	GetCommonQueries() url.Values
+
+	// SetCommonQuery sets a common query parameter of the current client.
+	// If the given value is an empty string, the corresponding query parameter will be deleted.
+	// The query parameters of the request url and of the request take precedence over the
+	// common query parameters, see Request.WithoutQuery for removing them from a request.
+	SetCommonQuery(string, string) Client
+
+	// SetCommonQueries resets the common query parameters of the current client.
+	// If nil is given, all common query parameters will be deleted.
+	SetCommonQueries(url.Values) Client
+
 	// SetResponder sets the given responder to the current client.
 	SetResponder(Responder) Client
 
+	// GetBaseURL returns the base URL of the current client.
+	GetBaseURL() string
+
+	// SetBaseURL sets the base URL of the current client.
+	// Relative request uris are resolved against the base URL when the request is sent,
+	// absolute request uris are used as they are. Setting it to an empty string removes
+	// the base URL.
+	// The Unix domain socket URL, such as "unix:///var/run/docker.sock" (the "http+unix"
+	// and "https+unix" schemes are also supported), sends all requests through the socket
+	// (see SetUnixSocket), and the relative request uris are resolved against
+	// "http://localhost". The socket path can also be given as the percent-encoded host,
+	// such as "http+unix://%2Fvar%2Frun%2Fdocker.sock/v1.41", whose path is the base path.
+	SetBaseURL(string) Client
+
+	// Use adds the given middlewares to the current client.
+	// The client middlewares are executed in the order they are added, and before the
+	// middlewares of the request.
+	Use(...Middleware) Client
+
+	// SetRetryPolicy sets the retry policy of the current client.
+	// If nil is given, requests are not retried.
+	// This retry policy can be overridden by each request retry policy.
+	SetRetryPolicy(*RetryPolicy) Client
+
+	// SetCircuitBreaker enables the per-host circuit breaker of the current client with the
+	// given options. If nil is given, the circuit breaker is disabled.
+	// Requests to a host whose circuit is open fail fast with ErrCircuitOpen.
+	SetCircuitBreaker(*CircuitBreakerOptions) Client
+
+	// SetRateLimiter enables the rate limiter of the current client with the given options.
+	// If nil is given, the rate limiter is disabled.
+	SetRateLimiter(*RateLimiterOptions) Client
+
+	// SetBulkhead enables the concurrency limiter of the current client with the given options.
+	// If nil is given, the concurrency limiter is disabled.
+	SetBulkhead(*BulkheadOptions) Client
+
+	// BulkheadStats returns the current statistics of the concurrency limiter.
+	// If the concurrency limiter is disabled, the zero value is returned.
+	BulkheadStats() BulkheadStats
+
+	// SetLoadBalancer enables the client-side load balancer of the current client with the
+	// given options. If nil is given, the load balancer is disabled.
+	// Relative request uris are resolved against the endpoint selected for each attempt
+	// of the request (instead of the base URL), absolute request uris are not balanced.
+	SetLoadBalancer(*LoadBalancerOptions) Client
+
+	// LoadBalancerStats returns the current statistics of the load balancer endpoints.
+	// If the load balancer is disabled, nil is returned.
+	LoadBalancerStats() []LoadBalancerEndpointStats
+
+	// SetAuthenticator sets the authenticator of the current client.
+	// If nil is given, requests are not authenticated.
+	// This authenticator can be overridden or disabled by each request.
+	SetAuthenticator(Authenticator) Client
+
+	// SetSigner sets the request signer of the current client.
+	// If nil is given, requests are not signed.
+	// This signer can be overridden by each request signer.
+	SetSigner(Signer) Client
+
+	// SetCookieJar sets the cookie jar of the current client.
+	// The cookie jar is used for all requests including redirects, and takes precedence
+	// over the cookie jar of the HTTP client. If nil is given, the cookie jar of the HTTP
+	// client is used. See NewCookieJar for the built-in persistent cookie jar.
+	SetCookieJar(http.CookieJar) Client
+
+	// SetProxy sets the proxy of the current client.
+	// The proxy is applied on top of the transport of the HTTP client, which must be an
+	// *http.Transport (the built-in default HTTP client is), otherwise the proxy is ignored.
+	// If nil is given, the proxy of the transport (from the environment by default) is used.
+	// This proxy can be overridden or disabled by each request.
+	SetProxy(*ProxyOptions) Client
+
+	// SetTLSConfig sets the TLS configuration of the current client, which replaces the TLS
+	// configuration of the transport of the HTTP client. Like the proxy, it only applies to
+	// an *http.Transport. See NewTLSConfig for creating the configuration from files.
+	// If nil is given, the TLS configuration of the transport is used.
+	SetTLSConfig(*tls.Config) Client
+
+	// SetResolver sets the host name resolution of the current client, which resolves the
+	// hosts dialed by the transport of the HTTP client. Like the proxy, it only applies to
+	// an *http.Transport. If nil is given, the dial function of the transport is used.
+	SetResolver(*ResolverOptions) Client
+
+	// SetUnixSocket sets the path of the Unix domain socket that all requests of the current
+	// client are sent through, the hosts of the request urls are only used as the Host
+	// headers. If the client has no base URL, the relative request uris are resolved
+	// against "http://localhost". Like the proxy, it only applies to an *http.Transport.
+	// Setting it to an empty string sends the requests over the network.
+	SetUnixSocket(string) Client
+
+	// SetHandler sets the handler that serves all requests of the current client in memory,
+	// without opening any connection (see NewHandlerTransport). The cookie jar, timeout and
+	// redirect policy of the HTTP client still apply, while the proxy, TLS, resolver and
+	// Unix domain socket configurations are ignored. If the client has no base URL, the
+	// relative request uris are resolved against "http://localhost".
+	// If nil is given, the requests are sent by the transport of the HTTP client.
+	SetHandler(http.Handler) Client
+
+	// SetTimings enables or disables capturing the timings of all requests of the current
+	// client, which are reported by the Response.Timings method (see Request.WithTimings).
+	SetTimings(bool) Client
+
+	// Clone returns an independent copy of the current client.
+	// The copy shares the HTTP client (and its connection pool), the authenticator, the
+	// signer, the cookie jar, the DNS cache and the state of the circuit breaker, rate
+	// limiter, concurrency limiter and load balancer with the current client, while the
+	// setters of either client (including the common headers) do not affect the other one.
+	Clone() Client
+
+	// With returns a copy of the current client (see Clone) with the given options applied.
+	With(...ClientOption) Client
+
 	// New returns a new request instance from the given uri.
 	New(string) Request
 
@@ -93,68 +223,390 @@ type Client interface {
 	UploadFile(string, string, interface{}) (Response, error)
 }
 
+// ClientOption type defines the option applied to the client by Client.With.
+// The option is usually a closure that calls the setters of the given client.
+type ClientOption func(Client)
+
 // The New function creates and returns a new built-in Client instance.
 func New() Client {
-	return &client{headers: make(http.Header)}
+	c := &client{derived: newDerivedTransport()}
+	c.config.Store(&clientConfig{headers: make(http.Header)})
+	return c
 }
 
 // The client type is a built-in implementation of the Client interface.
+// The configuration of the client is an immutable snapshot, the setters store a modified
+// copy of it atomically, and each request captures the snapshot once when it is sent,
+// so the client can be configured while requests are in flight.
 type client struct {
-	http      *http.Client
-	timeout   time.Duration
-	headers   http.Header
-	responder Responder
+	mutex   sync.Mutex
+	config  atomic.Value
+	derived *derivedTransport
+}
+
+// The clientConfig type is the configuration snapshot of the client.
+// It must not be modified after it is stored, the maps and slices are copied on write.
+type clientConfig struct {
+	http        *http.Client
+	timeout     time.Duration
+	headers     http.Header
+	query       url.Values
+	responder   Responder
+	baseURL     string
+	middlewares []Middleware
+	retry       *RetryPolicy
+	breaker     *circuitBreaker
+	limiter     *rateLimiter
+	bulkhead    *bulkhead
+	balancer    *loadBalancer
+	auth        Authenticator
+	signer      Signer
+	jar         http.CookieJar
+	proxy       *proxySelector
+	tls         *tls.Config
+	resolver    *dnsResolver
+	unixSocket  string
+	handler     http.RoundTripper
+	timings     bool
+}
+
+// The load method returns the current configuration snapshot.
+func (c *client) load() *clientConfig {
+	return c.config.Load().(*clientConfig)
+}
+
+// The localHost constant is the host of the requests whose uri is relative, if the requests
+// are not sent over the network (see SetUnixSocket and SetHandler) and there is no base URL.
+const localHost = "localhost"
+
+// The base method returns the base URL that the relative request uris are resolved against.
+func (config *clientConfig) base() string {
+	if _, base, ok := parseUnixSocketURL(config.baseURL); ok {
+		return base
+	}
+	if config.baseURL == "" && (config.unixSocket != "" || config.handler != nil) {
+		return "http://" + localHost
+	}
+	return config.baseURL
+}
+
+// The update method applies the given function to a copy of the current configuration,
+// and stores the copy as the new snapshot. Concurrent updates are serialized.
+func (c *client) update(f func(*clientConfig)) Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	config := *c.load()
+	f(&config)
+	c.config.Store(&config)
+	return c
 }
 
 // SetHTTPClient sets a private HTTP client instance for the current client.
 // If a nil is given, the built-in default HTTP client is used.
 func (c *client) SetHTTPClient(client *http.Client) Client {
-	c.http = client
-	return c
+	return c.update(func(config *clientConfig) { config.http = client })
 }
 
 // SetTimeout sets a request timeout period for the current client.
 // Setting it to 0 will never time out.
 // This timeout period can be overridden by each request timeout setting.
 func (c *client) SetTimeout(t time.Duration) Client {
-	c.timeout = t
-	return c
+	return c.update(func(config *clientConfig) { config.timeout = t })
 }
 
-// GetCommonHeaders returns the common request headers of the current client.
+// GetCommonHeaders returns a copy of the common request headers of the current client.
+// Modifying the returned headers does not affect the client, use the setters instead.
 func (c *client) GetCommonHeaders() http.Header {
-	return c.headers
+	return c.load().headers.Clone()
 }
 
 // SetCommonHeader sets a common request header of the current client.
 // If the given request header value is an empty string, the corresponding request
 // header will be deleted.
 func (c *client) SetCommonHeader(key, value string) Client {
-	if value == "" {
-		if len(c.headers) > 0 {
-			c.headers.Del(key)
+	return c.update(func(config *clientConfig) {
+		config.headers = config.headers.Clone()
+		if value == "" {
+			config.headers.Del(key)
+		} else {
+			config.headers.Set(key, value)
 		}
-		return c
-	}
-	c.headers.Set(key, value)
-	return c
+	})
 }
 
 // SetCommonHeaders resets the common request headers of the current client.
 // If nil is given, all common request headers will be deleted.
 func (c *client) SetCommonHeaders(headers http.Header) Client {
-	if headers == nil {
-		c.headers = make(http.Header)
-	} else {
-		c.headers = headers.Clone()
+	return c.update(func(config *clientConfig) {
+		if headers == nil {
+			config.headers = make(http.Header)
+		} else {
+			config.headers = headers.Clone()
+		}
+	})
+}
+
+// GetCommonQueries returns a copy of the common query parameters of the current client.
+func (c *client) GetCommonQueries() url.Values {
+	config := c.load()
+	query := make(url.Values, len(config.query))
+	for key, values := range config.query {
+		query[key] = append([]string(nil), values...)
 	}
-	return c
+	return query
+}
+
+// SetCommonQuery sets a common query parameter of the current client.
+// If the given value is an empty string, the corresponding query parameter will be deleted.
+// The query parameters of the request url and of the request take precedence over the
+// common query parameters, see Request.WithoutQuery for removing them from a request.
+func (c *client) SetCommonQuery(key, value string) Client {
+	return c.update(func(config *clientConfig) {
+		query := make(url.Values, len(config.query)+1)
+		for k, values := range config.query {
+			query[k] = values
+		}
+		if value == "" {
+			delete(query, key)
+		} else {
+			query[key] = []string{value}
+		}
+		config.query = query
+	})
+}
+
+// SetCommonQueries resets the common query parameters of the current client.
+// If nil is given, all common query parameters will be deleted.
+func (c *client) SetCommonQueries(query url.Values) Client {
+	return c.update(func(config *clientConfig) {
+		config.query = make(url.Values, len(query))
+		for key, values := range query {
+			config.query[key] = append([]string(nil), values...)
+		}
+	})
 }
 
 // SetResponder sets the given responder to the current client.
 func (c *client) SetResponder(responder Responder) Client {
-	c.responder = responder
-	return c
+	return c.update(func(config *clientConfig) { config.responder = responder })
+}
+
+// GetBaseURL returns the base URL of the current client.
+func (c *client) GetBaseURL() string {
+	return c.load().baseURL
+}
+
+// SetBaseURL sets the base URL of the current client.
+// Relative request uris are resolved against the base URL when the request is sent,
+// absolute request uris are used as they are. Setting it to an empty string removes
+// the base URL.
+// The Unix domain socket URL, such as "unix:///var/run/docker.sock" (the "http+unix"
+// and "https+unix" schemes are also supported), sends all requests through the socket
+// (see SetUnixSocket), and the relative request uris are resolved against
+// "http://localhost". The socket path can also be given as the percent-encoded host,
+// such as "http+unix://%2Fvar%2Frun%2Fdocker.sock/v1.41", whose path is the base path.
+func (c *client) SetBaseURL(base string) Client {
+	return c.update(func(config *clientConfig) { config.baseURL = base })
+}
+
+// Use adds the given middlewares to the current client.
+// The client middlewares are executed in the order they are added, and before the
+// middlewares of the request.
+func (c *client) Use(middlewares ...Middleware) Client {
+	return c.update(func(config *clientConfig) {
+		// The full slice expression forces a copy, the snapshot must not be modified.
+		n := len(config.middlewares)
+		config.middlewares = appendMiddlewares(config.middlewares[:n:n], middlewares)
+	})
+}
+
+// SetRetryPolicy sets the retry policy of the current client.
+// If nil is given, requests are not retried.
+// This retry policy can be overridden by each request retry policy.
+func (c *client) SetRetryPolicy(policy *RetryPolicy) Client {
+	return c.update(func(config *clientConfig) { config.retry = policy })
+}
+
+// SetCircuitBreaker enables the per-host circuit breaker of the current client with the
+// given options. If nil is given, the circuit breaker is disabled.
+// Requests to a host whose circuit is open fail fast with ErrCircuitOpen.
+func (c *client) SetCircuitBreaker(options *CircuitBreakerOptions) Client {
+	return c.update(func(config *clientConfig) {
+		if options == nil {
+			config.breaker = nil
+		} else {
+			config.breaker = newCircuitBreaker(options)
+		}
+	})
+}
+
+// SetRateLimiter enables the rate limiter of the current client with the given options.
+// If nil is given, the rate limiter is disabled.
+func (c *client) SetRateLimiter(options *RateLimiterOptions) Client {
+	return c.update(func(config *clientConfig) {
+		if options == nil {
+			config.limiter = nil
+		} else {
+			config.limiter = newRateLimiter(options)
+		}
+	})
+}
+
+// SetBulkhead enables the concurrency limiter of the current client with the given options.
+// If nil is given, the concurrency limiter is disabled.
+func (c *client) SetBulkhead(options *BulkheadOptions) Client {
+	return c.update(func(config *clientConfig) {
+		if options == nil {
+			config.bulkhead = nil
+		} else {
+			config.bulkhead = newBulkhead(options)
+		}
+	})
+}
+
+// BulkheadStats returns the current statistics of the concurrency limiter.
+// If the concurrency limiter is disabled, the zero value is returned.
+func (c *client) BulkheadStats() BulkheadStats {
+	if b := c.load().bulkhead; b != nil {
+		return b.stats()
+	}
+	return BulkheadStats{}
+}
+
+// SetLoadBalancer enables the client-side load balancer of the current client with the
+// given options. If nil is given, the load balancer is disabled.
+// Relative request uris are resolved against the endpoint selected for each attempt
+// of the request (instead of the base URL), absolute request uris are not balanced.
+func (c *client) SetLoadBalancer(options *LoadBalancerOptions) Client {
+	return c.update(func(config *clientConfig) {
+		if options == nil {
+			config.balancer = nil
+		} else {
+			config.balancer = newLoadBalancer(options)
+		}
+	})
+}
+
+// LoadBalancerStats returns the current statistics of the load balancer endpoints.
+// If the load balancer is disabled, nil is returned.
+func (c *client) LoadBalancerStats() []LoadBalancerEndpointStats {
+	if b := c.load().balancer; b != nil {
+		return b.stats()
+	}
+	return nil
+}
+
+// SetAuthenticator sets the authenticator of the current client.
+// If nil is given, requests are not authenticated.
+// This authenticator can be overridden or disabled by each request.
+func (c *client) SetAuthenticator(auth Authenticator) Client {
+	return c.update(func(config *clientConfig) { config.auth = auth })
+}
+
+// SetSigner sets the request signer of the current client.
+// If nil is given, requests are not signed.
+// This signer can be overridden by each request signer.
+func (c *client) SetSigner(signer Signer) Client {
+	return c.update(func(config *clientConfig) { config.signer = signer })
+}
+
+// SetCookieJar sets the cookie jar of the current client.
+// The cookie jar is used for all requests including redirects, and takes precedence
+// over the cookie jar of the HTTP client. If nil is given, the cookie jar of the HTTP
+// client is used. See NewCookieJar for the built-in persistent cookie jar.
+func (c *client) SetCookieJar(jar http.CookieJar) Client {
+	return c.update(func(config *clientConfig) { config.jar = jar })
+}
+
+// SetProxy sets the proxy of the current client.
+// The proxy is applied on top of the transport of the HTTP client, which must be an
+// *http.Transport (the built-in default HTTP client is), otherwise the proxy is ignored.
+// If nil is given, the proxy of the transport (from the environment by default) is used.
+// This proxy can be overridden or disabled by each request.
+func (c *client) SetProxy(options *ProxyOptions) Client {
+	return c.update(func(config *clientConfig) {
+		if options == nil {
+			config.proxy = nil
+		} else {
+			config.proxy = newProxySelector(options)
+		}
+	})
+}
+
+// SetTLSConfig sets the TLS configuration of the current client, which replaces the TLS
+// configuration of the transport of the HTTP client. Like the proxy, it only applies to
+// an *http.Transport. See NewTLSConfig for creating the configuration from files.
+// If nil is given, the TLS configuration of the transport is used.
+func (c *client) SetTLSConfig(tlsConfig *tls.Config) Client {
+	return c.update(func(config *clientConfig) { config.tls = tlsConfig })
+}
+
+// SetResolver sets the host name resolution of the current client, which resolves the
+// hosts dialed by the transport of the HTTP client. Like the proxy, it only applies to
+// an *http.Transport. If nil is given, the dial function of the transport is used.
+func (c *client) SetResolver(options *ResolverOptions) Client {
+	return c.update(func(config *clientConfig) {
+		if options == nil {
+			config.resolver = nil
+		} else {
+			config.resolver = newDNSResolver(options)
+		}
+	})
+}
+
+// SetUnixSocket sets the path of the Unix domain socket that all requests of the current
+// client are sent through, the hosts of the request urls are only used as the Host
+// headers. If the client has no base URL, the relative request uris are resolved
+// against "http://localhost". Like the proxy, it only applies to an *http.Transport.
+// Setting it to an empty string sends the requests over the network.
+func (c *client) SetUnixSocket(socket string) Client {
+	return c.update(func(config *clientConfig) { config.unixSocket = socket })
+}
+
+// SetHandler sets the handler that serves all requests of the current client in memory,
+// without opening any connection (see NewHandlerTransport). The cookie jar, timeout and
+// redirect policy of the HTTP client still apply, while the proxy, TLS, resolver and
+// Unix domain socket configurations are ignored. If the client has no base URL, the
+// relative request uris are resolved against "http://localhost".
+// If nil is given, the requests are sent by the transport of the HTTP client.
+func (c *client) SetHandler(handler http.Handler) Client {
+	return c.update(func(config *clientConfig) {
+		if handler == nil {
+			config.handler = nil
+		} else {
+			config.handler = NewHandlerTransport(handler)
+		}
+	})
+}
+
+// SetTimings enables or disables capturing the timings of all requests of the current
+// client, which are reported by the Response.Timings method (see Request.WithTimings).
+func (c *client) SetTimings(enabled bool) Client {
+	return c.update(func(config *clientConfig) { config.timings = enabled })
+}
+
+// Clone returns an independent copy of the current client.
+// The copy shares the HTTP client (and its connection pool), the authenticator, the
+// signer, the cookie jar, the DNS cache and the state of the circuit breaker, rate
+// limiter, concurrency limiter and load balancer with the current client, while the
+// setters of either client (including the common headers) do not affect the other one.
+func (c *client) Clone() Client {
+	// The snapshot is immutable, so it can be shared until either client is configured.
+	copied := &client{derived: c.derived.clone()}
+	copied.config.Store(c.load())
+	return copied
+}
+
+// With returns a copy of the current client (see Clone) with the given options applied.
+func (c *client) With(options ...ClientOption) Client {
+	copied := c.Clone()
+	for _, option := range options {
+		if option != nil {
+			option(copied)
+		}
+	}
+	return copied
 }
 
 // New returns a new request instance from the given uri.
@@ -23,6 +23,8 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -287,3 +289,305 @@ func TestClient_UploadFile(t *testing.T) {
 		}
 	}
 }
+
+func TestClient_SetBaseURL(t *testing.T) {
+	c := New()
+	if c.SetBaseURL("http://test.com/api") == nil {
+		t.Fatal("Client.SetBaseURL() return nil")
+	}
+	if got := c.GetBaseURL(); got != "http://test.com/api" {
+		t.Fatalf("Client.GetBaseURL() return %q", got)
+	}
+	if c.SetBaseURL("") == nil {
+		t.Fatal("Client.SetBaseURL() return nil")
+	}
+}
+
+func TestClient_BaseURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer server.Close()
+
+	c := New().SetBaseURL(server.URL + "/api/v1?version=1")
+
+	items := []struct {
+		Give  string
+		Query url.Values
+		Want  string
+	}{
+		{"", nil, "/api/v1?version=1"},
+		{"users", nil, "/api/v1/users?version=1"},
+		{"/users/", nil, "/api/v1/users/?version=1"},
+		{"../v2/users", nil, "/api/v2/users?version=1"},
+		{"users?version=2&page=1", nil, "/api/v1/users?page=1&version=2"},
+		{"users?page=1", url.Values{"page": {"2"}}, "/api/v1/users?page=2&version=1"},
+		{server.URL + "/other", nil, "/other"},
+	}
+
+	for i, item := range items {
+		r, err := c.New(item.Give).WithQueries(item.Query).Get()
+		if err != nil {
+			t.Fatalf("[%d] Request.Get() error: %s", i, err)
+		}
+		if got := r.String(); got != item.Want {
+			t.Fatalf("[%d] Request.Get() want %q got %q", i, item.Want, got)
+		}
+	}
+
+	if _, err := c.SetBaseURL("::////").New("users").Get(); err == nil {
+		t.Fatal("Request.Get() with invalid base url return nil error")
+	}
+}
+
+func TestClient_Clone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Service") + "|" + r.Header.Get("X-Base") + "|" + r.Header.Get("X-Middleware")))
+	}))
+	defer server.Close()
+
+	base := New().SetBaseURL(server.URL).SetCommonHeader("X-Base", "1").SetTimeout(time.Second)
+	base.Use(func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Header.Add("X-Middleware", "base")
+			return next(req)
+		}
+	})
+
+	c := base.Clone()
+	if c == nil || c == base {
+		t.Fatal("Client.Clone() return the same client")
+	}
+	c.SetCommonHeader("X-Service", "a").SetCommonHeader("X-Base", "2").SetTimeout(time.Minute)
+	c.Use(func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Header.Add("X-Middleware", "clone")
+			return next(req)
+		}
+	})
+
+	if got := base.GetCommonHeaders(); got.Get("X-Service") != "" || got.Get("X-Base") != "1" {
+		t.Fatalf("Client.Clone(): base headers %v", got)
+	}
+	if got := c.GetBaseURL(); got != server.URL {
+		t.Fatalf("Client.Clone(): base url %s", got)
+	}
+	if base.(*client).load().timeout != time.Second || c.(*client).load().timeout != time.Minute {
+		t.Fatal("Client.Clone(): timeout changed")
+	}
+
+	res, err := base.New("/").Get()
+	if err != nil {
+		t.Fatalf("Client.Get(): %s", err)
+	}
+	if got := res.String(); got != "|1|base" {
+		t.Fatalf("Client.Clone(): base %s", got)
+	}
+	if res, err = c.New("/").Get(); err != nil {
+		t.Fatalf("Client.Get(): %s", err)
+	}
+	if got := res.String(); got != "a|2|base" {
+		t.Fatalf("Client.Clone(): clone %s", got)
+	}
+}
+
+func TestClient_Clone_Transport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	parent := New().SetHTTPClient(NewHTTPClient(nil)).SetResolver(&ResolverOptions{}).SetTimings(true)
+	if _, err := parent.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get(): %s", err)
+	}
+
+	// Changing the configuration of the clone keeps the connections of the parent.
+	child := parent.Clone().SetResolver(&ResolverOptions{})
+	if _, err := child.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get(): %s", err)
+	}
+	res, err := parent.Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Client.Get(): %s", err)
+	}
+	if timings := res.Timings(); timings == nil || !timings.ConnReused {
+		t.Fatalf("Client.Clone(): %+v", timings)
+	}
+}
+
+func TestClient_Clone_SharedTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// The clones created before the first request share the derived transport.
+	config := server.Client().Transport.(*http.Transport).TLSClientConfig
+	base := New().SetHTTPClient(NewHTTPClient(nil)).SetTLSConfig(config).SetTimings(true)
+	a, b := base.Clone(), base.Clone()
+	if _, err := a.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get(): %s", err)
+	}
+	res, err := b.Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Client.Get(): %s", err)
+	}
+	if timings := res.Timings(); timings == nil || !timings.ConnReused {
+		t.Fatalf("Client.Clone(): %+v", timings)
+	}
+}
+
+func TestClient_With(t *testing.T) {
+	base := New().SetCommonHeader("X-Base", "1")
+	c := base.With(
+		func(c Client) { c.SetCommonHeader("X-Service", "b") },
+		nil,
+		func(c Client) { c.SetResponder(NewResponse) },
+	)
+	if got := c.GetCommonHeaders(); got.Get("X-Base") != "1" || got.Get("X-Service") != "b" {
+		t.Fatalf("Client.With(): %v", got)
+	}
+	if got := base.GetCommonHeaders(); got.Get("X-Service") != "" {
+		t.Fatalf("Client.With(): base %v", got)
+	}
+	if base.(*client).load().responder != nil || c.(*client).load().responder == nil {
+		t.Fatal("Client.With(): responder")
+	}
+}
+
+func TestClient_ConcurrentConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Version")))
+	}))
+	defer server.Close()
+
+	c := New().SetBaseURL(server.URL).SetCommonHeader("X-Version", "init")
+	stop := make(chan struct{})
+	var setters, senders sync.WaitGroup
+
+	setters.Add(1)
+	go func() {
+		defer setters.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			c.SetCommonHeader("X-Version", strconv.Itoa(i))
+			c.SetCommonHeaders(http.Header{"X-Version": []string{"headers"}})
+			c.SetTimeout(time.Duration(i%2+1) * time.Second)
+			c.SetBaseURL(server.URL)
+			c.SetResponder(NewResponse)
+			c.SetRetryPolicy(&RetryPolicy{MaxAttempts: 1})
+			c.SetAuthenticator(NewBearerAuthenticator(strconv.Itoa(i)))
+			c.SetSigner(nil)
+			c.SetCookieJar(NewCookieJar(nil))
+			c.SetBulkhead(&BulkheadOptions{MaxConcurrent: 100})
+			c.SetProxy(nil)
+			c.SetTLSConfig(nil)
+			if i%10 == 0 {
+				c.Use(func(next Handler) Handler { return next })
+			}
+			_ = c.GetCommonHeaders()
+			_ = c.GetBaseURL()
+			_ = c.BulkheadStats()
+			_ = c.Clone().SetCommonHeader("X-Clone", "1")
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for j := 0; j < 20; j++ {
+				res, err := c.Do("/", func(r Request) (Response, error) {
+					return r.WithHeader("X-Request", "1").Get()
+				})
+				if err != nil {
+					t.Errorf("Client.Do(): %s", err)
+					return
+				}
+				if res.String() == "" {
+					t.Error("Client.Do(): empty common header")
+					return
+				}
+			}
+		}()
+	}
+	senders.Wait()
+	close(stop)
+	setters.Wait()
+}
+
+func TestDefault_ConcurrentConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	defer Default().SetCommonHeader("X-Test", "")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				Default().SetCommonHeader("X-Test", strconv.Itoa(i*j))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := Default().Get(server.URL, nil); err != nil {
+					t.Errorf("Client.Get(): %s", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClient_SetCommonQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer server.Close()
+
+	c := New().SetBaseURL(server.URL)
+	if c.SetCommonQuery("api_version", "1") == nil {
+		t.Fatal("Client.SetCommonQuery() return nil")
+	}
+	c.SetCommonQuery("tenant", "a").SetCommonQuery("debug", "1").SetCommonQuery("debug", "")
+	if got := c.GetCommonQueries().Encode(); got != "api_version=1&tenant=a" {
+		t.Fatalf("Client.GetCommonQueries(): %s", got)
+	}
+	c.GetCommonQueries().Set("tenant", "changed")
+
+	items := []struct {
+		Request Request
+		Want    string
+	}{
+		{c.New("/"), "api_version=1&tenant=a"},
+		{c.New("/?tenant=b&x=1"), "api_version=1&tenant=b&x=1"},
+		{c.New("/?tenant=b").WithQuery("tenant", "c"), "api_version=1&tenant=c"},
+		{c.New("/").WithoutQuery("tenant", "missing"), "api_version=1"},
+		{c.New("/?tenant=b").WithoutQuery("tenant"), "api_version=1&tenant=b"},
+		{c.New("/").WithoutQuery("api_version").WithQuery("api_version", "2"), "api_version=2&tenant=a"},
+	}
+	for i, item := range items {
+		res, err := item.Request.Get()
+		if err != nil {
+			t.Fatalf("Request.Get(): %d %s", i, err)
+		}
+		if got := res.String(); got != item.Want {
+			t.Fatalf("Client.SetCommonQuery(): %d %s", i, got)
+		}
+	}
+
+	if c.SetCommonQueries(url.Values{"v": []string{"3"}}) == nil {
+		t.Fatal("Client.SetCommonQueries() return nil")
+	}
+	if got := c.GetCommonQueries().Encode(); got != "v=3" {
+		t.Fatalf("Client.SetCommonQueries(): %s", got)
+	}
+	if got := c.SetCommonQueries(nil).GetCommonQueries(); len(got) != 0 {
+		t.Fatalf("Client.SetCommonQueries(): %v", got)
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edoger/zkits-requester/internal"
+)
+
+// CookieJar interface defines a cookie jar that can be saved and loaded.
+// The cookie jar follows the storage model of RFC 6265.
+type CookieJar interface {
+	http.CookieJar
+
+	// Save writes all the cookies to be kept to the given writer as json.
+	Save(io.Writer) error
+
+	// Load reads the cookies from the given reader written by Save, expired cookies are
+	// discarded, and existing cookies with the same name, domain and path are replaced.
+	Load(io.Reader) error
+
+	// SaveFile writes all the cookies to be kept to the given file atomically.
+	SaveFile(string) error
+
+	// LoadFile reads the cookies from the given file written by SaveFile.
+	// If the file does not exist, no error is returned.
+	LoadFile(string) error
+
+	// Clear removes all the cookies.
+	Clear()
+}
+
+// CookieJarOptions defines the built-in cookie jar.
+type CookieJarOptions struct {
+	// PublicSuffixList is used to reject cookies for public suffixes such as "com".
+	// If it is nil, only the domain matching rules are applied.
+	PublicSuffixList cookiejar.PublicSuffixList
+
+	// KeepSessionCookies saves the session cookies (without expiry) as well as the
+	// persistent cookies, so that sessions are kept across runs.
+	KeepSessionCookies bool
+}
+
+// NewCookieJar returns a new built-in cookie jar with the given options.
+// If nil is given, the default options are used.
+func NewCookieJar(options *CookieJarOptions) CookieJar {
+	j := &cookieJar{entries: make(map[string]*cookieEntry)}
+	if options != nil {
+		j.options = *options
+	}
+	return j
+}
+
+// The cookieJar type is a built-in implementation of the CookieJar interface.
+type cookieJar struct {
+	options CookieJarOptions
+	mutex   sync.Mutex
+	entries map[string]*cookieEntry
+	seq     uint64
+}
+
+// The cookieEntry type is a stored cookie.
+type cookieEntry struct {
+	Name       string    `json:"name"`
+	Value      string    `json:"value"`
+	Domain     string    `json:"domain"`
+	Path       string    `json:"path"`
+	Secure     bool      `json:"secure"`
+	HttpOnly   bool      `json:"http_only"`
+	HostOnly   bool      `json:"host_only"`
+	Persistent bool      `json:"persistent"`
+	Expires    time.Time `json:"expires"`
+	Creation   time.Time `json:"creation"`
+	LastAccess time.Time `json:"last_access"`
+
+	// The seq field orders the entries created at the same time.
+	seq uint64
+}
+
+// The id method returns the unique identifier of the entry.
+func (e *cookieEntry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+// The expired method determines whether the entry is expired at the given time.
+func (e *cookieEntry) expired(now time.Time) bool {
+	return e.Persistent && !now.Before(e.Expires)
+}
+
+// The normalize method fixes the fields of the entry read by Load, and reports whether
+// the entry is valid.
+func (e *cookieEntry) normalize(now time.Time) bool {
+	e.Domain = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(e.Domain, "."), "."))
+	if e.Name == "" || e.Domain == "" || e.expired(now) {
+		return false
+	}
+	if e.Path == "" || e.Path[0] != '/' {
+		e.Path = "/"
+	}
+	if e.Creation.IsZero() {
+		e.Creation = now
+	}
+	if e.LastAccess.IsZero() {
+		e.LastAccess = e.Creation
+	}
+	return true
+}
+
+// The match method determines whether the entry should be sent to the given host and path.
+func (e *cookieEntry) match(https bool, host, path string) bool {
+	if e.Secure && !https {
+		return false
+	}
+	if e.HostOnly {
+		if host != e.Domain {
+			return false
+		}
+	} else if !domainMatch(host, e.Domain) {
+		return false
+	}
+	return pathMatch(path, e.Path)
+}
+
+// Cookies returns the cookies to send in a request for the given URL.
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	host, err := canonicalCookieHost(u.Host)
+	if err != nil {
+		return nil
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	https := u.Scheme == "https"
+	now := time.Now()
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var selected []*cookieEntry
+	for id, e := range j.entries {
+		if e.expired(now) {
+			delete(j.entries, id)
+			continue
+		}
+		if e.match(https, host, path) {
+			e.LastAccess = now
+			selected = append(selected, e)
+		}
+	}
+	// RFC 6265 section 5.4: longer paths first, then earlier creation times first.
+	sort.Slice(selected, func(a, b int) bool {
+		if len(selected[a].Path) != len(selected[b].Path) {
+			return len(selected[a].Path) > len(selected[b].Path)
+		}
+		if !selected[a].Creation.Equal(selected[b].Creation) {
+			return selected[a].Creation.Before(selected[b].Creation)
+		}
+		return selected[a].seq < selected[b].seq
+	})
+
+	cookies := make([]*http.Cookie, len(selected))
+	for i, e := range selected {
+		cookies[i] = &http.Cookie{Name: e.Name, Value: e.Value}
+	}
+	return cookies
+}
+
+// SetCookies handles the receipt of the cookies in a reply for the given URL.
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if len(cookies) == 0 || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+	host, err := canonicalCookieHost(u.Host)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	defaultPath := defaultCookiePath(u.Path)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for _, c := range cookies {
+		e, remove, ok := j.newEntry(c, u.Scheme == "https", host, defaultPath, now)
+		if !ok {
+			continue
+		}
+		id := e.id()
+		if remove {
+			delete(j.entries, id)
+			continue
+		}
+		if old, found := j.entries[id]; found {
+			e.Creation, e.seq = old.Creation, old.seq
+		} else {
+			j.seq++
+			e.seq = j.seq
+		}
+		j.entries[id] = e
+	}
+}
+
+// The newEntry method creates an entry from the given cookie by the storage model of
+// RFC 6265 section 5.3. It reports whether the entry should be removed, and whether the
+// cookie is acceptable.
+func (j *cookieJar) newEntry(c *http.Cookie, https bool, host, defaultPath string, now time.Time) (*cookieEntry, bool, bool) {
+	e := &cookieEntry{
+		Name:       c.Name,
+		Value:      c.Value,
+		Secure:     c.Secure,
+		HttpOnly:   c.HttpOnly,
+		Creation:   now,
+		LastAccess: now,
+	}
+	// A non-secure origin can not set secure cookies.
+	if e.Secure && !https {
+		return nil, false, false
+	}
+
+	if c.Path == "" || c.Path[0] != '/' {
+		e.Path = defaultPath
+	} else {
+		e.Path = c.Path
+	}
+
+	domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	switch {
+	case domain == "" || domain == host:
+		e.Domain, e.HostOnly = host, c.Domain == ""
+		if j.isPublicSuffix(host) && !e.HostOnly {
+			// A public suffix is only acceptable as a host-only cookie for itself.
+			e.HostOnly = true
+		}
+	case net.ParseIP(host) != nil:
+		// IP addresses only accept host-only cookies.
+		return nil, false, false
+	case j.isPublicSuffix(domain):
+		return nil, false, false
+	case !domainMatch(host, domain):
+		return nil, false, false
+	default:
+		e.Domain = domain
+	}
+
+	switch {
+	case c.MaxAge < 0:
+		return e, true, true
+	case c.MaxAge > 0:
+		e.Persistent, e.Expires = true, now.Add(time.Duration(c.MaxAge)*time.Second)
+	case !c.Expires.IsZero():
+		if !c.Expires.After(now) {
+			return e, true, true
+		}
+		e.Persistent, e.Expires = true, c.Expires
+	}
+	return e, false, true
+}
+
+// The isPublicSuffix method determines whether the given domain is a public suffix.
+func (j *cookieJar) isPublicSuffix(domain string) bool {
+	if j.options.PublicSuffixList == nil || net.ParseIP(domain) != nil {
+		return false
+	}
+	return j.options.PublicSuffixList.PublicSuffix(domain) == domain
+}
+
+// Save writes all the cookies to be kept to the given writer as json.
+func (j *cookieJar) Save(w io.Writer) error {
+	now := time.Now()
+
+	j.mutex.Lock()
+	entries := make([]*cookieEntry, 0, len(j.entries))
+	for _, e := range j.entries {
+		if !e.expired(now) && (e.Persistent || j.options.KeepSessionCookies) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].id() < entries[b].id() })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	j.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Load reads the cookies from the given reader written by Save, expired and invalid
+// cookies are discarded, and existing cookies with the same name, domain and path are
+// replaced. The cookies without a path are sent to all paths.
+func (j *cookieJar) Load(r io.Reader) error {
+	var entries []*cookieEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+	now := time.Now()
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for _, e := range entries {
+		if e != nil && e.normalize(now) {
+			j.seq++
+			e.seq = j.seq
+			j.entries[e.id()] = e
+		}
+	}
+	return nil
+}
+
+// SaveFile writes all the cookies to be kept to the given file atomically.
+func (j *cookieJar) SaveFile(name string) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if err = f.Chmod(0600); err == nil {
+		err = j.Save(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// LoadFile reads the cookies from the given file written by SaveFile.
+// If the file does not exist, no error is returned.
+func (j *cookieJar) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer internal.ForceClose(f)
+
+	return j.Load(f)
+}
+
+// Clear removes all the cookies.
+func (j *cookieJar) Clear() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.entries = make(map[string]*cookieEntry)
+}
+
+// The canonicalCookieHost function returns the lowercase host without port.
+func canonicalCookieHost(host string) (string, error) {
+	if strings.LastIndexByte(host, ':') > strings.LastIndexByte(host, ']') {
+		h, _, err := net.SplitHostPort(host)
+		if err != nil {
+			return "", err
+		}
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), ".")), nil
+}
+
+// The domainMatch function implements the domain matching of RFC 6265 section 5.1.3.
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil
+}
+
+// The pathMatch function implements the path matching of RFC 6265 section 5.1.4.
+func pathMatch(path, cookiePath string) bool {
+	if path == cookiePath {
+		return true
+	}
+	if strings.HasPrefix(path, cookiePath) {
+		return strings.HasSuffix(cookiePath, "/") || path[len(cookiePath)] == '/'
+	}
+	return false
+}
+
+// The defaultCookiePath function implements the default path of RFC 6265 section 5.1.4.
+func defaultCookiePath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndexByte(path, '/')
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
@@ -0,0 +1,241 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPublicSuffixList struct{}
+
+func (testPublicSuffixList) PublicSuffix(domain string) string {
+	if i := strings.LastIndexByte(domain, '.'); i >= 0 {
+		return domain[i+1:]
+	}
+	return domain
+}
+
+func (testPublicSuffixList) String() string {
+	return "test"
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatalf("url.Parse(): %s", err)
+	}
+	return u
+}
+
+func cookieString(cookies []*http.Cookie) string {
+	items := make([]string, len(cookies))
+	for i, c := range cookies {
+		items[i] = c.String()
+	}
+	return strings.Join(items, "; ")
+}
+
+func TestNewCookieJar(t *testing.T) {
+	if NewCookieJar(nil) == nil {
+		t.Fatal("NewCookieJar() return nil")
+	}
+	if NewCookieJar(&CookieJarOptions{KeepSessionCookies: true}) == nil {
+		t.Fatal("NewCookieJar() return nil")
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	jar := NewCookieJar(&CookieJarOptions{PublicSuffixList: testPublicSuffixList{}})
+	jar.SetCookies(mustParseURL(t, "http://www.example.com:8080/a/b"), []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".example.com", Path: "/"},
+		{Name: "path", Value: "3", Path: "/a/b"},
+		{Name: "secure", Value: "4", Secure: true},
+		{Name: "public", Value: "5", Domain: "com"},
+		{Name: "other", Value: "6", Domain: "other.com"},
+	})
+	jar.SetCookies(mustParseURL(t, "https://www.example.com/"), []*http.Cookie{
+		{Name: "secure", Value: "7", Secure: true, MaxAge: 60},
+	})
+
+	items := []struct {
+		URL  string
+		Want string
+	}{
+		{"http://www.example.com/a/b/c", "path=3; host=1; domain=2"},
+		{"http://www.example.com/a/bc", "host=1; domain=2"},
+		{"https://www.example.com/a", "host=1; domain=2; secure=7"},
+		{"http://api.example.com/a/b", "domain=2"},
+		{"http://example.com/", "domain=2"},
+		{"http://other.com/", ""},
+		{"ftp://www.example.com/a/b", ""},
+	}
+	for i, item := range items {
+		if got := cookieString(jar.Cookies(mustParseURL(t, item.URL))); got != item.Want {
+			t.Fatalf("CookieJar.Cookies(): %d %s: got %q, want %q", i, item.URL, got, item.Want)
+		}
+	}
+
+	// Expired and deleted cookies.
+	jar.SetCookies(mustParseURL(t, "http://www.example.com/a/b"), []*http.Cookie{
+		{Name: "host", MaxAge: -1},
+		{Name: "domain", Domain: "example.com", Path: "/", Expires: time.Now().Add(-time.Hour)},
+	})
+	if got := cookieString(jar.Cookies(mustParseURL(t, "http://www.example.com/a/b"))); got != "path=3" {
+		t.Fatalf("CookieJar.Cookies(): %q", got)
+	}
+
+	jar.Clear()
+	if got := jar.Cookies(mustParseURL(t, "http://www.example.com/a/b")); len(got) != 0 {
+		t.Fatalf("CookieJar.Cookies(): %v", got)
+	}
+}
+
+func TestCookieJar_IP(t *testing.T) {
+	jar := NewCookieJar(nil)
+	jar.SetCookies(mustParseURL(t, "http://127.0.0.1/"), []*http.Cookie{
+		{Name: "a", Value: "1"},
+		{Name: "b", Value: "2", Domain: "0.0.1"},
+		{Name: "c", Value: "3", Domain: "127.0.0.1"},
+	})
+	if got := cookieString(jar.Cookies(mustParseURL(t, "http://127.0.0.1:8080/x"))); got != "a=1; c=3" {
+		t.Fatalf("CookieJar.Cookies(): %q", got)
+	}
+}
+
+func TestCookieJar_SaveLoad(t *testing.T) {
+	u := mustParseURL(t, "https://example.com/")
+	jar := NewCookieJar(nil)
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "1"},
+		{Name: "persistent", Value: "2", MaxAge: 3600, Secure: true},
+	})
+
+	buf := new(bytes.Buffer)
+	if err := jar.Save(buf); err != nil {
+		t.Fatalf("CookieJar.Save(): %s", err)
+	}
+	loaded := NewCookieJar(nil)
+	if err := loaded.Load(buf); err != nil {
+		t.Fatalf("CookieJar.Load(): %s", err)
+	}
+	if got := cookieString(loaded.Cookies(u)); got != "persistent=2" {
+		t.Fatalf("CookieJar.Load(): %q", got)
+	}
+	if got := loaded.Cookies(mustParseURL(t, "http://example.com/")); len(got) != 0 {
+		t.Fatalf("CookieJar.Load(): %v", got)
+	}
+
+	dir, err := ioutil.TempDir("", "requester")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(): %s", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	name := filepath.Join(dir, "cookies.json")
+
+	jar = NewCookieJar(&CookieJarOptions{KeepSessionCookies: true})
+	if err := jar.LoadFile(name); err != nil {
+		t.Fatalf("CookieJar.LoadFile(): %s", err)
+	}
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "1"}})
+	if err := jar.SaveFile(name); err != nil {
+		t.Fatalf("CookieJar.SaveFile(): %s", err)
+	}
+	if fi, err := os.Stat(name); err != nil {
+		t.Fatalf("os.Stat(): %s", err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Fatalf("CookieJar.SaveFile(): mode %s", fi.Mode())
+	}
+	loaded = NewCookieJar(nil)
+	if err := loaded.LoadFile(name); err != nil {
+		t.Fatalf("CookieJar.LoadFile(): %s", err)
+	}
+	if got := cookieString(loaded.Cookies(u)); got != "session=1" {
+		t.Fatalf("CookieJar.LoadFile(): %q", got)
+	}
+
+	if err := loaded.Load(strings.NewReader("{")); err == nil {
+		t.Fatal("CookieJar.Load(): nil error")
+	}
+
+	// The invalid entries are discarded, and the missing path is defaulted.
+	loaded = NewCookieJar(nil)
+	data := `[{"name":"a","value":"1","domain":".Example.com","path":""},{"name":"b","domain":""},{"name":"","domain":"example.com"}]`
+	if err := loaded.Load(strings.NewReader(data)); err != nil {
+		t.Fatalf("CookieJar.Load(): %s", err)
+	}
+	if got := cookieString(loaded.Cookies(mustParseURL(t, "https://www.example.com/a/b"))); got != "a=1" {
+		t.Fatalf("CookieJar.Load(): %q", got)
+	}
+}
+
+func TestClient_SetCookieJar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			http.Redirect(w, r, "/home", http.StatusFound)
+		default:
+			var names []string
+			for _, c := range r.Cookies() {
+				names = append(names, c.Name+"="+c.Value)
+			}
+			http.SetCookie(w, &http.Cookie{Name: "seen", Value: "1"})
+			_, _ = io.WriteString(w, strings.Join(names, ","))
+		}
+	}))
+	defer server.Close()
+
+	jar := NewCookieJar(nil)
+	c := New().SetBaseURL(server.URL).SetCookieJar(jar)
+
+	res, err := c.New("/login").Get()
+	if err != nil {
+		t.Fatalf("Request.Get(): %s", err)
+	}
+	if got := res.String(); got != "session=abc" {
+		t.Fatalf("Request.Get(): %s", got)
+	}
+	if got := res.Cookies(); len(got) != 1 || got[0].Name != "seen" || got[0].Value != "1" {
+		t.Fatalf("Response.Cookies(): %v", got)
+	}
+
+	res, err = c.New("/home").WithCookie(&http.Cookie{Name: "extra", Value: "x"}).WithCookie(nil).Get()
+	if err != nil {
+		t.Fatalf("Request.Get(): %s", err)
+	}
+	if got := res.String(); got != "extra=x,session=abc,seen=1" {
+		t.Fatalf("Request.WithCookie(): %s", got)
+	}
+
+	// The request cookies work without a cookie jar.
+	res, err = New().New(server.URL + "/home").WithCookie(&http.Cookie{Name: "extra", Value: "x"}).Get()
+	if err != nil {
+		t.Fatalf("Request.Get(): %s", err)
+	}
+	if got := res.String(); got != "extra=x" {
+		t.Fatalf("Request.WithCookie(): %s", got)
+	}
+}
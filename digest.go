@@ -0,0 +1,261 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// NewDigestAuthenticator returns an authenticator that uses the HTTP Digest authentication
+// (RFC 7616). The first request to a host is sent without credentials, the challenge of the
+// 401 response is cached per host and the request is sent again with the credentials.
+// Subsequent requests to the same host are authenticated with the cached challenge.
+// The MD5, MD5-sess, SHA-256 and SHA-256-sess algorithms and the "auth" and "auth-int"
+// quality of protection are supported.
+func NewDigestAuthenticator(username, password string) Authenticator {
+	return &digestAuthenticator{username: username, password: password, hosts: make(map[string]*digestChallenge)}
+}
+
+// The digestAuthenticator type is a built-in implementation of the HTTP Digest authentication.
+type digestAuthenticator struct {
+	username string
+	password string
+	mutex    sync.Mutex
+	hosts    map[string]*digestChallenge
+}
+
+// The digestChallenge type is a parsed Digest challenge.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	stale     bool
+	count     uint32
+}
+
+// Authenticate adds the credentials to the given HTTP request.
+func (a *digestAuthenticator) Authenticate(req *http.Request) error {
+	a.mutex.Lock()
+	c := a.hosts[req.URL.Host]
+	var nc uint32
+	var challenge digestChallenge
+	if c != nil {
+		c.count++
+		nc, challenge = c.count, *c
+	}
+	a.mutex.Unlock()
+
+	if c == nil {
+		// There is no challenge yet, the server will send one.
+		return nil
+	}
+	var body []byte
+	if challenge.qop == "auth-int" {
+		var err error
+		if body, err = readRequestBody(req); err != nil {
+			return err
+		}
+	}
+	auth, err := a.authorization(&challenge, req, nc, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	return nil
+}
+
+// String returns the redacted description of the authenticator.
+func (a *digestAuthenticator) String() string {
+	return "Digest " + a.username + ":" + RedactedValue
+}
+
+// The challenge method caches the Digest challenge of the given 401 response, and reports
+// whether the request should be sent again with the new challenge.
+func (a *digestAuthenticator) challenge(req *http.Request, o *http.Response) bool {
+	if o.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	c := parseDigestChallenge(o.Header.Values("Www-Authenticate"))
+	if c == nil {
+		return false
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	old := a.hosts[req.URL.Host]
+	a.hosts[req.URL.Host] = c
+	// If the request was authenticated with the same challenge and the nonce is not stale,
+	// the credentials are wrong and sending the request again is useless.
+	authorized := req.Header.Get("Authorization") != ""
+	return !authorized || c.stale || old == nil || old.nonce != c.nonce
+}
+
+// The authorization method computes the Authorization header value.
+func (a *digestAuthenticator) authorization(c *digestChallenge, req *http.Request, nc uint32, body []byte) (string, error) {
+	algorithm := strings.ToUpper(c.algorithm)
+	var h func() hash.Hash
+	switch strings.TrimSuffix(algorithm, "-SESS") {
+	case "", "MD5":
+		h = md5.New
+	case "SHA-256":
+		h = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm: %s", c.algorithm)
+	}
+	digest := func(s string) string {
+		w := h()
+		_, _ = w.Write([]byte(s))
+		return hex.EncodeToString(w.Sum(nil))
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(b)
+	count := fmt.Sprintf("%08x", nc)
+	uri := req.URL.RequestURI()
+
+	ha1 := digest(a.username + ":" + c.realm + ":" + a.password)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = digest(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := digest(req.Method + ":" + uri)
+	if c.qop == "auth-int" {
+		ha2 = digest(req.Method + ":" + uri + ":" + digest(string(body)))
+	}
+
+	var response string
+	if c.qop == "" {
+		response = digest(ha1 + ":" + c.nonce + ":" + ha2)
+	} else {
+		response = digest(ha1 + ":" + c.nonce + ":" + count + ":" + cnonce + ":" + c.qop + ":" + ha2)
+	}
+
+	s := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		quoteEscape(a.username), quoteEscape(c.realm), quoteEscape(c.nonce), quoteEscape(uri), response)
+	if c.algorithm != "" {
+		s += ", algorithm=" + c.algorithm
+	}
+	if c.qop != "" {
+		s += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, c.qop, count, cnonce)
+	}
+	if c.opaque != "" {
+		s += fmt.Sprintf(`, opaque="%s"`, quoteEscape(c.opaque))
+	}
+	return s, nil
+}
+
+// The quoteEscape function escapes the given string for a quoted-string.
+func quoteEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// The parseDigestChallenge function parses the Digest challenge from the given
+// "WWW-Authenticate" header values. If the server offers multiple Digest challenges,
+// the strongest supported algorithm is preferred.
+func parseDigestChallenge(values []string) *digestChallenge {
+	var found *digestChallenge
+	for _, value := range values {
+		if len(value) < 7 || !strings.EqualFold(value[:7], "Digest ") {
+			continue
+		}
+		params := parseAuthParams(value[7:])
+		c := &digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: params["algorithm"],
+			stale:     strings.EqualFold(params["stale"], "true"),
+		}
+		if c.nonce == "" {
+			continue
+		}
+		switch strings.TrimSuffix(strings.ToUpper(c.algorithm), "-SESS") {
+		case "", "MD5", "SHA-256":
+		default:
+			continue
+		}
+		// Prefer "auth", because "auth-int" requires reading the whole request body.
+		for _, qop := range strings.Split(params["qop"], ",") {
+			switch qop = strings.TrimSpace(qop); qop {
+			case "auth":
+				c.qop = qop
+			case "auth-int":
+				if c.qop == "" {
+					c.qop = qop
+				}
+			}
+		}
+		if params["qop"] != "" && c.qop == "" {
+			continue
+		}
+		if found == nil || strings.HasPrefix(strings.ToUpper(c.algorithm), "SHA-256") {
+			found = c
+		}
+	}
+	return found
+}
+
+// The parseAuthParams function parses the comma-separated auth-param list, the keys are
+// converted to lowercase and the quoted values are unquoted.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			j := 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			value = b.String()
+			if j < len(s) {
+				j++
+			}
+			s = s[j:]
+		} else {
+			j := strings.IndexByte(s, ',')
+			if j < 0 {
+				j = len(s)
+			}
+			value = strings.TrimSpace(s[:j])
+			s = s[j:]
+		}
+		params[key] = value
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestDigestServer(t *testing.T, algorithm, qop string, count *int32) *httptest.Server {
+	h := md5.New
+	if strings.HasPrefix(algorithm, "SHA-256") {
+		h = sha256.New
+	}
+	digest := func(s string) string {
+		w := h()
+		_, _ = w.Write([]byte(s))
+		return hex.EncodeToString(w.Sum(nil))
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(count, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		params := parseAuthParams(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest "))
+
+		ha1 := digest("user:test@example.com:pass")
+		if strings.HasSuffix(algorithm, "-sess") {
+			ha1 = digest(ha1 + ":nonce:" + params["cnonce"])
+		}
+		ha2 := digest(r.Method + ":" + r.URL.RequestURI())
+		if params["qop"] == "auth-int" {
+			ha2 = digest(r.Method + ":" + r.URL.RequestURI() + ":" + digest(string(body)))
+		}
+		want := digest(ha1 + ":nonce:" + params["nc"] + ":" + params["cnonce"] + ":" + params["qop"] + ":" + ha2)
+
+		if params["response"] != want || params["uri"] != r.URL.RequestURI() || params["opaque"] != "op" {
+			w.Header().Add("WWW-Authenticate", `Basic realm="test"`)
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(
+				`Digest realm="test@example.com", qop="%s", algorithm=%s, nonce="nonce", opaque="op"`, qop, algorithm))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, params["nc"]+"|"+string(body))
+	}))
+}
+
+func TestDigestAuthenticator(t *testing.T) {
+	items := []struct {
+		Algorithm string
+		QOP       string
+	}{
+		{"MD5", "auth"},
+		{"MD5-sess", "auth,auth-int"},
+		{"SHA-256", "auth-int"},
+		{"SHA-256-sess", "auth"},
+	}
+	for i, item := range items {
+		var count int32
+		server := newTestDigestServer(t, item.Algorithm, item.QOP, &count)
+
+		c := New().SetAuthenticator(NewDigestAuthenticator("user", "pass"))
+		res, err := c.New(server.URL + "/dir/index.html?a=1").WithBody(strings.NewReader("body")).SendBy(http.MethodPut)
+		if err != nil {
+			t.Fatalf("[%d] Request.SendBy() error: %s", i, err)
+		}
+		if got := res.String(); got != "00000001|body" {
+			t.Fatalf("[%d] Request.SendBy() got %d %q", i, res.StatusCode(), got)
+		}
+		// The cached challenge is used directly.
+		res, err = c.Post(server.URL+"/other", "post")
+		if err != nil {
+			t.Fatalf("[%d] Client.Post() error: %s", i, err)
+		}
+		if got := res.String(); got != "00000002|post" {
+			t.Fatalf("[%d] Client.Post() got %d %q", i, res.StatusCode(), got)
+		}
+		if n := atomic.LoadInt32(&count); n != 3 {
+			t.Fatalf("[%d] sent %d requests", i, n)
+		}
+		server.Close()
+	}
+}
+
+func TestDigestAuthenticator_WrongPassword(t *testing.T) {
+	var count int32
+	server := newTestDigestServer(t, "MD5", "auth", &count)
+	defer server.Close()
+
+	a := NewDigestAuthenticator("user", "wrong")
+	if s := fmt.Sprint(a); strings.Contains(s, "wrong") {
+		t.Fatalf("DigestAuthenticator string: %s", s)
+	}
+	c := New().SetAuthenticator(a)
+	for i := 0; i < 2; i++ {
+		res, err := c.Get(server.URL, nil)
+		if err != nil {
+			t.Fatalf("Client.Get() error: %s", err)
+		}
+		if res.StatusCode() != http.StatusUnauthorized {
+			t.Fatalf("Client.Get() got %d", res.StatusCode())
+		}
+	}
+	if n := atomic.LoadInt32(&count); n != 3 {
+		t.Fatalf("sent %d requests", n)
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	c := parseDigestChallenge([]string{
+		`Digest realm="a", nonce="n1", algorithm=MD5, qop="auth"`,
+		`Digest realm="b \"q\"", nonce="n2", algorithm=SHA-256, qop="auth-int", stale=TRUE`,
+		`Digest realm="c", nonce="n3", algorithm=SHA-512-256`,
+	})
+	if c == nil {
+		t.Fatal("parseDigestChallenge() return nil")
+	}
+	if c.realm != `b "q"` || c.nonce != "n2" || c.algorithm != "SHA-256" || c.qop != "auth-int" || !c.stale {
+		t.Fatalf("parseDigestChallenge() got %+v", c)
+	}
+	if parseDigestChallenge([]string{`Basic realm="a"`, `Digest realm="a", qop="foo", nonce="n"`}) != nil {
+		t.Fatal("parseDigestChallenge() with unsupported challenge return non-nil")
+	}
+}
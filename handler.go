@@ -0,0 +1,364 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The handlerRemoteAddr constant is the remote address of the requests served by the
+// handler transport.
+const handlerRemoteAddr = "127.0.0.1:1234"
+
+// The handlerBufferSize constant is the size of the response body buffered before the
+// response headers are sent, like the HTTP server does.
+const handlerBufferSize = 2048
+
+// NewHandlerTransport creates a transport that serves the requests by the given handler
+// in memory, without opening any connection. The requests received by the handler are
+// like the requests received by an HTTP server: the Host and RequestURI fields are set
+// from the request url, the RemoteAddr field is "127.0.0.1:1234", and the TLS field is set
+// for the HTTPS requests. The response body is streamed while the handler is writing,
+// the handler supports http.Flusher and the response trailers, and the context of the
+// request is cancelled when the client cancels the request or closes the response body.
+func NewHandlerTransport(handler http.Handler) http.RoundTripper {
+	return &handlerTransport{handler: handler}
+}
+
+// The handlerTransport type is a built-in implementation of the http.RoundTripper
+// interface that serves the requests by an http.Handler.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip serves the given request by the handler and returns the response.
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	sr, err := newHandlerRequest(ctx, req)
+	if err != nil {
+		cancel()
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	w := &handlerResponseWriter{
+		req:    req,
+		header: make(http.Header),
+		noBody: req.Method == http.MethodHead,
+		pipe:   pw,
+		ready:  make(chan struct{}),
+	}
+	w.body = &handlerResponseBody{reader: pr, cancel: cancel}
+	go w.serve(t.handler, sr, cancel)
+
+	select {
+	case <-w.ready:
+	case <-req.Context().Done():
+		// The handler is still running, its writes fail after the pipe is closed.
+		_ = w.body.Close()
+		return nil, req.Context().Err()
+	}
+	if w.err != nil {
+		_ = w.body.Close()
+		return nil, w.err
+	}
+	return w.res, nil
+}
+
+// The newHandlerRequest function creates the request received by the handler from the
+// given client request.
+func newHandlerRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	if req.URL == nil {
+		return nil, fmt.Errorf("http: nil Request.URL")
+	}
+	sr := req.Clone(ctx)
+	sr.RequestURI = req.URL.RequestURI()
+	u, err := url.ParseRequestURI(sr.RequestURI)
+	if err != nil {
+		return nil, err
+	}
+	sr.URL = u
+	if sr.Host == "" {
+		sr.Host = req.URL.Host
+	}
+	sr.Proto, sr.ProtoMajor, sr.ProtoMinor = "HTTP/1.1", 1, 1
+	sr.RemoteAddr = handlerRemoteAddr
+	sr.Close = false
+	sr.GetBody = nil
+	if _, ok := sr.Header["User-Agent"]; !ok {
+		sr.Header.Set("User-Agent", "Go-http-client/1.1")
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		sr.Body, sr.ContentLength = http.NoBody, 0
+	} else if sr.ContentLength == 0 {
+		// The length of the request body is unknown.
+		sr.ContentLength = -1
+	}
+	if req.URL.Scheme == "https" {
+		host := sr.Host
+		if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+			host = host[:i]
+		}
+		sr.TLS = &tls.ConnectionState{
+			Version:           tls.VersionTLS13,
+			HandshakeComplete: true,
+			ServerName:        strings.Trim(host, "[]"),
+		}
+	}
+	return sr, nil
+}
+
+// The handlerResponseWriter type is the http.ResponseWriter of the handler transport.
+type handlerResponseWriter struct {
+	req       *http.Request
+	header    http.Header
+	status    int
+	noBody    bool
+	buf       bytes.Buffer
+	committed bool
+	pipe      *io.PipeWriter
+	body      *handlerResponseBody
+	res       *http.Response
+	err       error
+	ready     chan struct{}
+}
+
+// Header returns the response headers.
+func (w *handlerResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader sets the status code of the response.
+// The informational status codes are ignored, and only the first call takes effect.
+func (w *handlerResponseWriter) WriteHeader(code int) {
+	if code < 100 || code > 999 {
+		panic(fmt.Sprintf("invalid WriteHeader code %v", code))
+	}
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+}
+
+// Write writes the given data to the response body.
+func (w *handlerResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.bodyAllowed() {
+		if w.noBody {
+			return len(p), nil
+		}
+		return 0, http.ErrBodyNotAllowed
+	}
+	if w.committed {
+		return w.pipe.Write(p)
+	}
+	n, _ := w.buf.Write(p)
+	if w.buf.Len() > handlerBufferSize {
+		w.commit(-1)
+		if _, err := w.pipe.Write(w.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		w.buf.Reset()
+	}
+	return n, nil
+}
+
+// Flush sends the response headers and the buffered response body to the client.
+func (w *handlerResponseWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.committed {
+		w.commit(-1)
+	}
+	if w.buf.Len() > 0 {
+		_, _ = w.pipe.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+// The bodyAllowed method determines whether the response can have a body.
+func (w *handlerResponseWriter) bodyAllowed() bool {
+	return !w.noBody && w.status != http.StatusNoContent && w.status != http.StatusNotModified
+}
+
+// The commit method builds the response from the current headers and sends it to the
+// client. The given length is the length of the response body, or -1 if it is unknown.
+func (w *handlerResponseWriter) commit(length int64) {
+	w.committed = true
+	header := w.header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	trailer := handlerTrailerKeys(header)
+	header.Del("Trailer")
+	for key := range header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			delete(header, key)
+		}
+	}
+	if _, ok := header["Date"]; !ok {
+		header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if _, ok := header["Content-Type"]; !ok && w.bodyAllowed() && w.buf.Len() > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	if s := header.Get("Content-Length"); s != "" {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+			length = n
+		}
+	} else if length >= 0 && trailer == nil && w.bodyAllowed() {
+		header.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	if !w.bodyAllowed() && !w.noBody {
+		length = 0
+	}
+
+	w.res = &http.Response{
+		Status:        strconv.Itoa(w.status) + " " + http.StatusText(w.status),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          w.body,
+		ContentLength: length,
+		Trailer:       trailer,
+		Request:       w.req,
+	}
+	if w.noBody {
+		// The response body of the HEAD request is always empty.
+		_ = w.body.Close()
+		w.res.Body = http.NoBody
+	}
+	if w.req.URL.Scheme == "https" {
+		w.res.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, HandshakeComplete: true}
+	}
+	close(w.ready)
+}
+
+// The finish method sends the trailers and ends the response body after the handler
+// returns.
+func (w *handlerResponseWriter) finish() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.committed {
+		w.commit(int64(w.buf.Len()))
+	}
+	if w.buf.Len() > 0 {
+		if _, err := w.pipe.Write(w.buf.Bytes()); err != nil {
+			return
+		}
+	}
+	// The values of the trailers are set before the client reads io.EOF.
+	for key := range w.res.Trailer {
+		w.res.Trailer[key] = w.header[key]
+	}
+	for key, values := range w.header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			if w.res.Trailer == nil {
+				w.res.Trailer = make(http.Header)
+			}
+			w.res.Trailer[http.CanonicalHeaderKey(key[len(http.TrailerPrefix):])] = values
+		}
+	}
+	_ = w.pipe.Close()
+}
+
+// The serve method serves the given request by the given handler.
+func (w *handlerResponseWriter) serve(handler http.Handler, req *http.Request, cancel context.CancelFunc) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// The response body fails when the client cancels the request.
+		select {
+		case <-done:
+		case <-w.req.Context().Done():
+			_ = w.pipe.CloseWithError(w.req.Context().Err())
+		}
+	}()
+
+	defer func() {
+		// The context of the request is cancelled after the handler returns, like the
+		// HTTP server does.
+		cancel()
+		_ = req.Body.Close()
+		if v := recover(); v != nil {
+			err := fmt.Errorf("http: panic serving %s: %v", req.URL, v)
+			if w.committed {
+				_ = w.pipe.CloseWithError(err)
+			} else {
+				w.err = err
+				close(w.ready)
+			}
+			return
+		}
+		w.finish()
+	}()
+	handler.ServeHTTP(w, req)
+}
+
+// The handlerTrailerKeys function returns the trailers declared by the "Trailer" header.
+func handlerTrailerKeys(header http.Header) http.Header {
+	var trailer http.Header
+	for _, value := range header["Trailer"] {
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				if trailer == nil {
+					trailer = make(http.Header)
+				}
+				trailer[http.CanonicalHeaderKey(key)] = nil
+			}
+		}
+	}
+	return trailer
+}
+
+// The handlerResponseBody type is the response body of the handler transport.
+type handlerResponseBody struct {
+	reader *io.PipeReader
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+// Read reads the response body written by the handler.
+func (b *handlerResponseBody) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
+
+// Close closes the response body, and cancels the context of the request received by
+// the handler, like closing the connection.
+func (b *handlerResponseBody) Close() error {
+	b.once.Do(func() {
+		_ = b.reader.Close()
+		b.cancel()
+	})
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_SetHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s %s %s %s %s %d %s %v",
+			r.Method, r.Host, r.RequestURI, r.RemoteAddr, r.Header.Get("X-Header"), r.ContentLength, body, r.TLS != nil)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Path: "/"})
+		http.Redirect(w, r, "/cookie", http.StatusFound)
+	})
+	mux.HandleFunc("/cookie", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, cookie.Value)
+	})
+
+	c := New().SetHandler(mux).SetCommonHeader("X-Header", "value")
+	res, err := c.New("/echo?a=1").WithBody("body").Post()
+	if err != nil {
+		t.Fatalf("Client.SetHandler(): %s", err)
+	}
+	if got := res.String(); got != "POST localhost /echo?a=1 127.0.0.1:1234 value 4 body false" {
+		t.Fatalf("Client.SetHandler(): %s", got)
+	}
+	if got := res.Headers().Get("Content-Length"); got != strconv.Itoa(res.Len()) {
+		t.Fatalf("Client.SetHandler(): Content-Length %q", got)
+	}
+	if got := res.Headers().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Fatalf("Client.SetHandler(): Content-Type %q", got)
+	}
+
+	res, err = c.New("https://api.example.test:8443/echo").Get()
+	if err != nil {
+		t.Fatalf("Client.SetHandler(): %s", err)
+	}
+	if got := res.String(); got != "GET api.example.test:8443 /echo 127.0.0.1:1234 value 0  true" {
+		t.Fatalf("Client.SetHandler(): %s", got)
+	}
+
+	c.SetCookieJar(NewCookieJar(nil))
+	if res, err = c.New("/redirect").Get(); err != nil {
+		t.Fatalf("Client.SetHandler(): %s", err)
+	}
+	if res.StatusCode() != http.StatusOK || res.String() != "1" {
+		t.Fatalf("Client.SetHandler(): %d %s", res.StatusCode(), res.String())
+	}
+
+	if res, err = c.New("/missing").Head(); err != nil {
+		t.Fatalf("Client.SetHandler(): %s", err)
+	}
+	if res.StatusCode() != http.StatusNotFound || res.Len() != 0 {
+		t.Fatalf("Client.SetHandler(): %d %s", res.StatusCode(), res.String())
+	}
+
+	// The requests are sent over the network again.
+	if _, err = c.SetHandler(nil).New("/echo").Get(); err == nil {
+		t.Fatal("Client.SetHandler(): nil error")
+	}
+}
+
+func TestHandlerTransport_Streaming(t *testing.T) {
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Sum")
+		_, _ = io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			close(cancelled)
+			return
+		}
+		_, _ = io.WriteString(w, strings.Repeat("x", handlerBufferSize+1))
+		w.Header().Set("X-Sum", "42")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "late")
+	})
+	hc := &http.Client{Transport: NewHandlerTransport(h)}
+
+	res, err := hc.Get("http://example.test/")
+	if err != nil {
+		t.Fatalf("HandlerTransport.RoundTrip(): %s", err)
+	}
+	if res.ContentLength != -1 || res.Header.Get("Trailer") != "" {
+		t.Fatalf("HandlerTransport.RoundTrip(): %d %v", res.ContentLength, res.Header)
+	}
+	if _, ok := res.Trailer["X-Sum"]; !ok {
+		t.Fatalf("HandlerTransport.RoundTrip(): trailer %v", res.Trailer)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(res.Body, buf); err != nil || string(buf) != "first" {
+		t.Fatalf("HandlerTransport.RoundTrip(): %q %v", buf, err)
+	}
+	close(release)
+	rest, err := ioutil.ReadAll(res.Body)
+	if err != nil || len(rest) != handlerBufferSize+1 {
+		t.Fatalf("HandlerTransport.RoundTrip(): %d %v", len(rest), err)
+	}
+	_ = res.Body.Close()
+	if got := res.Trailer.Get("X-Sum") + " " + res.Trailer.Get("X-Late"); got != "42 late" {
+		t.Fatalf("HandlerTransport.RoundTrip(): trailer %v", res.Trailer)
+	}
+
+	// Closing the response body cancels the context of the handler.
+	release = make(chan struct{})
+	if res, err = hc.Get("http://example.test/"); err != nil {
+		t.Fatalf("HandlerTransport.RoundTrip(): %s", err)
+	}
+	_ = res.Body.Close()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandlerTransport.RoundTrip(): the handler is not cancelled")
+	}
+}
+
+func TestHandlerTransport_Cancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		<-r.Context().Done()
+		close(cancelled)
+	})
+	c := New().SetHandler(h)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.New("/").WithContext(ctx).Get(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Client.SetHandler(): %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client.SetHandler(): the handler is not cancelled")
+	}
+
+	if _, err := c.New("/panic").Get(); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("Client.SetHandler(): %v", err)
+	}
+}
+
+func TestHandlerTransport_NoBody(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		if _, err := io.WriteString(w, "body"); err != http.ErrBodyNotAllowed {
+			t.Errorf("ResponseWriter.Write(): %v", err)
+		}
+	})
+	res, err := (&http.Client{Transport: NewHandlerTransport(h)}).Get("http://example.test/")
+	if err != nil {
+		t.Fatalf("HandlerTransport.RoundTrip(): %s", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusNoContent || len(body) != 0 || res.ContentLength != 0 {
+		t.Fatalf("HandlerTransport.RoundTrip(): %d %q %d", res.StatusCode, body, res.ContentLength)
+	}
+}
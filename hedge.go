@@ -0,0 +1,126 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// The hedgeAttemptKey type is the context key of the hedged attempt number.
+type hedgeAttemptKey struct{}
+
+// The hedgeResult type is the result of a hedged attempt.
+type hedgeResult struct {
+	attempt int
+	o       *http.Response
+	err     error
+}
+
+// HedgedAttempt returns the number of the hedged attempt that produced the given response,
+// 0 is the original attempt and n is the n-th hedge. If the request is not hedged, 0 is
+// returned. This function is useful for the custom responders.
+func HedgedAttempt(o *http.Response) int {
+	if o == nil || o.Request == nil {
+		return 0
+	}
+	n, _ := o.Request.Context().Value(hedgeAttemptKey{}).(int)
+	return n
+}
+
+// The hedgeHandler function returns the handler that hedges the given handler.
+// The original attempt is sent immediately, and if there is no response after the given
+// delay, a duplicate attempt is sent, up to the given number of hedges. The first
+// successful response (without error and with a status code less than 500) wins, the
+// other attempts are cancelled and their response bodies are drained. If all attempts
+// fail, the result of the last finished attempt is returned.
+func hedgeHandler(h Handler, delay time.Duration, hedges int) Handler {
+	return func(req *http.Request) (*http.Response, error) {
+		ctx := req.Context()
+		results := make(chan hedgeResult, hedges+1)
+		cancels := make([]context.CancelFunc, 0, hedges+1)
+		launch := func() {
+			attempt := len(cancels)
+			actx, cancel := context.WithCancel(context.WithValue(ctx, hedgeAttemptKey{}, attempt))
+			cancels = append(cancels, cancel)
+			go func() {
+				o, err := sendAttempt(req.WithContext(actx), h)
+				results <- hedgeResult{attempt: attempt, o: o, err: err}
+			}()
+		}
+
+		launch()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		var last *hedgeResult
+		for pending := 1; pending > 0; {
+			select {
+			case res := <-results:
+				pending--
+				if res.err == nil && res.o.StatusCode < http.StatusInternalServerError {
+					if last != nil && last.o != nil {
+						drainResponseBody(last.o)
+					}
+					return hedgeWinner(res, cancels, results, pending)
+				}
+				if last != nil {
+					if last.o != nil {
+						drainResponseBody(last.o)
+					}
+					cancels[last.attempt]()
+				}
+				last = &res
+			case <-timer.C:
+				// There is no need to send more attempts if the request is cancelled.
+				if len(cancels) <= hedges && ctx.Err() == nil {
+					launch()
+					pending++
+					if len(cancels) <= hedges {
+						timer.Reset(delay)
+					}
+				}
+			}
+		}
+		return hedgeWinner(*last, cancels, results, 0)
+	}
+}
+
+// The hedgeWinner function cancels the other attempts and returns the result of the
+// winner. The context of the winner is cancelled when its response body is closed.
+func hedgeWinner(res hedgeResult, cancels []context.CancelFunc, results chan hedgeResult, pending int) (*http.Response, error) {
+	for i, cancel := range cancels {
+		if i != res.attempt {
+			cancel()
+		}
+	}
+	if pending > 0 {
+		// Drain the responses of the cancelled attempts in the background.
+		go func() {
+			for ; pending > 0; pending-- {
+				if loser := <-results; loser.o != nil {
+					drainResponseBody(loser.o)
+				}
+			}
+		}()
+	}
+	if res.err != nil || res.o.Body == nil {
+		cancels[res.attempt]()
+		return res.o, res.err
+	}
+	res.o.Body = &releaseBody{ReadCloser: res.o.Body, release: cancels[res.attempt]}
+	return res.o, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequest_WithHedging(t *testing.T) {
+	var count int32
+	cancelled := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		n := atomic.AddInt32(&count, 1)
+		if n == 1 {
+			// The first attempt is slow, and it is cancelled when the hedge wins.
+			select {
+			case <-r.Context().Done():
+				cancelled <- struct{}{}
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		_, _ = io.WriteString(w, strconv.Itoa(int(n))+"|"+string(body))
+	}))
+	defer server.Close()
+
+	res, err := New().New(server.URL).WithHedging(20*time.Millisecond, 2).WithBody("body").SendBy(http.MethodPut)
+	if err != nil {
+		t.Fatalf("Request.WithHedging(): %s", err)
+	}
+	if got := res.String(); got != "2|body" {
+		t.Fatalf("Request.WithHedging(): %s", got)
+	}
+	if got := res.HedgedAttempt(); got != 1 {
+		t.Fatalf("Response.HedgedAttempt(): %d", got)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request.WithHedging(): the slow attempt is not cancelled")
+	}
+	if got := atomic.LoadInt32(&count); got != 2 {
+		t.Fatalf("Request.WithHedging(): count %d", got)
+	}
+}
+
+func TestRequest_WithHedging_Fast(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		if r.Method == http.MethodPost {
+			time.Sleep(50 * time.Millisecond)
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	c := New()
+	res, err := c.New(server.URL).WithHedging(time.Second, 2).Get()
+	if err != nil {
+		t.Fatalf("Request.WithHedging(): %s", err)
+	}
+	if got := res.String(); got != "ok" || res.HedgedAttempt() != 0 {
+		t.Fatalf("Request.WithHedging(): %s %d", got, res.HedgedAttempt())
+	}
+
+	// The non-idempotent requests are not hedged.
+	if _, err = c.New(server.URL).WithHedging(time.Millisecond, 2).Post(); err != nil {
+		t.Fatalf("Request.WithHedging(): %s", err)
+	}
+	// The hedging is disabled.
+	if _, err = c.New(server.URL).WithHedging(0, 2).Get(); err != nil {
+		t.Fatalf("Request.WithHedging(): %s", err)
+	}
+	if got := atomic.LoadInt32(&count); got != 3 {
+		t.Fatalf("Request.WithHedging(): count %d", got)
+	}
+}
+
+func TestRequest_WithHedging_Failure(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, strconv.Itoa(int(n)))
+	}))
+	defer server.Close()
+
+	res, err := New().New(server.URL).WithHedging(10*time.Millisecond, 2).Get()
+	if err != nil {
+		t.Fatalf("Request.WithHedging(): %s", err)
+	}
+	if res.StatusCode() != http.StatusInternalServerError || res.String() == "" {
+		t.Fatalf("Request.WithHedging(): %d %s", res.StatusCode(), res.String())
+	}
+	if got := atomic.LoadInt32(&count); got != 3 {
+		t.Fatalf("Request.WithHedging(): count %d", got)
+	}
+
+	if got := HedgedAttempt(nil); got != 0 {
+		t.Fatalf("HedgedAttempt(): %d", got)
+	}
+	if got := NewEmptyResponse().HedgedAttempt(); got != 0 {
+		t.Fatalf("NewEmptyResponse().HedgedAttempt(): %d", got)
+	}
+}
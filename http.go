@@ -15,7 +15,10 @@
 package requester
 
 import (
+	"crypto/tls"
+	"net"
 	"net/http"
+	"sync"
 
 	"github.com/edoger/zkits-requester/internal"
 )
@@ -29,3 +32,179 @@ func DefaultHTTPClient() *http.Client {
 func NewDefaultHTTPClient() *http.Client {
 	return internal.NewClient()
 }
+
+// The derivedKey type is the configuration that a derived transport is created from.
+type derivedKey struct {
+	base     *http.Transport
+	tls      *tls.Config
+	resolver *dnsResolver
+	socket   string
+}
+
+// The derivedEntry type is a derived transport shared by the clients with the same
+// configuration, and the number of the clients using it.
+type derivedEntry struct {
+	transport *http.Transport
+	refs      int
+}
+
+// The transportCache type caches the transports derived from the transport of the HTTP
+// client, which is shared by a client and its clones, so that the clients with the same
+// configuration share the derived transport (and its connection pool).
+type transportCache struct {
+	mutex   sync.Mutex
+	entries map[derivedKey]*derivedEntry
+}
+
+// The acquire method returns the derived transport of the given configuration, and counts
+// a user of it.
+func (c *transportCache) acquire(key derivedKey) *http.Transport {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e := c.entries[key]
+	if e == nil {
+		e = &derivedEntry{transport: deriveTransport(key)}
+		c.entries[key] = e
+	}
+	e.refs++
+	return e.transport
+}
+
+// The release method removes a user of the derived transport of the given configuration,
+// the transport is removed and its idle connections are closed after the last user.
+func (c *transportCache) release(key derivedKey) {
+	c.mutex.Lock()
+	e := c.entries[key]
+	if e.refs--; e.refs > 0 {
+		c.mutex.Unlock()
+		return
+	}
+	delete(c.entries, key)
+	c.mutex.Unlock()
+
+	e.transport.CloseIdleConnections()
+}
+
+// The deriveTransport function creates the transport derived from the given
+// configuration.
+func deriveTransport(key derivedKey) *http.Transport {
+	t := key.base.Clone()
+	if key.socket != "" {
+		// The requests through the Unix domain socket never go through the proxy.
+		t.Proxy = nil
+	} else {
+		t.Proxy = proxyFromContext(key.base.Proxy)
+	}
+	if key.tls != nil {
+		t.TLSClientConfig = key.tls.Clone()
+	}
+	dial := key.base.DialContext
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	// The hosts are not resolved for the Unix domain socket.
+	if key.socket != "" {
+		t.DialContext = unixSocketDialContext(dial, key.socket)
+	} else if key.resolver != nil {
+		t.DialContext = key.resolver.dialContext(dial)
+	}
+	return t
+}
+
+// The derivedTransport type holds the derived transport used by a client, which applies
+// the proxy, TLS, resolver and Unix domain socket configurations of the client.
+type derivedTransport struct {
+	mutex     sync.Mutex
+	cache     *transportCache
+	key       derivedKey
+	transport *http.Transport
+}
+
+// The newDerivedTransport function creates a derived transport holder with a new cache.
+func newDerivedTransport() *derivedTransport {
+	return &derivedTransport{cache: &transportCache{entries: make(map[derivedKey]*derivedEntry)}}
+}
+
+// The get method returns the transport derived from the given base transport.
+// The derived transport is taken from the cache again when the base transport, the TLS
+// configuration, the resolver or the Unix domain socket of the client changes.
+func (d *derivedTransport) get(base *http.Transport, config *tls.Config, resolver *dnsResolver, socket string) *http.Transport {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := derivedKey{base: base, tls: config, resolver: resolver, socket: socket}
+	if d.transport == nil || d.key != key {
+		transport := d.cache.acquire(key)
+		if d.transport != nil {
+			d.cache.release(d.key)
+		}
+		d.key, d.transport = key, transport
+	}
+	return d.transport
+}
+
+// The clone method returns a holder sharing the transport cache with the current one,
+// so that the clients share the derived transport (and its connection pool) as long as
+// their configurations are the same.
+func (d *derivedTransport) clone() *derivedTransport {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	copied := &derivedTransport{cache: d.cache, key: d.key}
+	if d.transport != nil {
+		copied.transport = d.cache.acquire(d.key)
+	}
+	return copied
+}
+
+// The httpClient method returns the HTTP client that sends the given request with the
+// given configuration snapshot. The cookie jar, the handler, and the proxy, TLS, resolver
+// and Unix domain socket configurations of the client are applied to a shallow copy of
+// the HTTP client, so that they also work for the redirects.
+func (c *client) httpClient(config *clientConfig, req *http.Request) *http.Client {
+	hc := config.http
+	if hc == nil {
+		hc = internal.Client
+	}
+	var transport http.RoundTripper
+	socket := config.socket()
+	if config.handler != nil {
+		transport = config.handler
+	} else if config.tls != nil || config.resolver != nil || socket != "" || req.Context().Value(proxyContextKey{}) != nil {
+		// These configurations can only be applied to the standard transport.
+		if base := baseTransport(hc); base != nil {
+			transport = c.derived.get(base, config.tls, config.resolver, socket)
+		}
+	}
+	if transport == nil && (config.jar == nil || config.jar == hc.Jar) {
+		return hc
+	}
+	copied := *hc
+	if transport != nil {
+		copied.Transport = transport
+	}
+	if config.jar != nil {
+		copied.Jar = config.jar
+	}
+	return &copied
+}
+
+// The sender method returns the handler that sends the requests by the HTTP client of the
+// given configuration snapshot, without the middlewares and the built-in features.
+func (c *client) sender(config *clientConfig) Handler {
+	return func(req *http.Request) (*http.Response, error) {
+		return c.httpClient(config, req).Do(req)
+	}
+}
+
+// The baseTransport function returns the standard transport of the given HTTP client,
+// or nil if the HTTP client uses a custom transport.
+func baseTransport(hc *http.Client) *http.Transport {
+	if hc.Transport == nil {
+		t, _ := http.DefaultTransport.(*http.Transport)
+		return t
+	}
+	t, _ := hc.Transport.(*http.Transport)
+	return t
+}
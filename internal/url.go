@@ -0,0 +1,87 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ResolveURL resolves the given uri against the given base URL.
+// If the base URL is empty, or the given uri is absolute (has a scheme or a host),
+// the given uri is used as it is.
+// Unlike plain RFC 3986 reference resolution, the path of the base URL is always treated
+// as a directory prefix, and a leading slash of the given uri is relative to that prefix,
+// so that "http://host/api" joined with "/users" produces "http://host/api/users".
+// The query parameters of the base URL are merged with the query parameters of the given
+// uri, and the latter take precedence.
+func ResolveURL(base, uri string) (*url.URL, error) {
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if base == "" || ref.Scheme != "" {
+		return ref, nil
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Host != "" {
+		// Network-path reference, only the scheme of the base URL is inherited.
+		return b.ResolveReference(ref), nil
+	}
+
+	prefix := *b
+	prefix.RawQuery = ""
+	prefix.Fragment = ""
+	if !strings.HasSuffix(prefix.Path, "/") {
+		prefix.Path += "/"
+		if prefix.RawPath != "" {
+			prefix.RawPath += "/"
+		}
+	}
+
+	var u *url.URL
+	if ref.Path == "" {
+		// The base URL itself is the target, keep its path untouched.
+		u = b
+		u.Fragment = ref.Fragment
+	} else {
+		rel := *ref
+		rel.Path = strings.TrimPrefix(rel.Path, "/")
+		rel.RawPath = strings.TrimPrefix(rel.RawPath, "/")
+		u = prefix.ResolveReference(&rel)
+	}
+	u.RawQuery = MergeRawQuery(b.RawQuery, ref.RawQuery)
+	return u, nil
+}
+
+// MergeRawQuery merges the given raw query strings, parameters in the latter query string
+// replace the parameters with the same name in the former query string.
+func MergeRawQuery(base, over string) string {
+	if base == "" {
+		return over
+	}
+	if over == "" {
+		return base
+	}
+	qs, _ := url.ParseQuery(base)
+	qo, _ := url.ParseQuery(over)
+	for key, values := range qo {
+		qs[key] = values
+	}
+	return qs.Encode()
+}
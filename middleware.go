@@ -0,0 +1,106 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// Handler defines the function that sends the given HTTP request and returns the
+// received HTTP response.
+type Handler func(*http.Request) (*http.Response, error)
+
+// Middleware defines the request middleware.
+// The middleware receives the next handler and returns a new handler that wraps it,
+// it can modify the request before calling the next handler, inspect or replace the
+// returned response, or return a synthetic response without calling the next handler.
+type Middleware func(next Handler) Handler
+
+// The appendMiddlewares function appends the given non-nil middlewares to the given
+// middleware list and returns the new list.
+func appendMiddlewares(dst []Middleware, middlewares []Middleware) []Middleware {
+	for i, j := 0, len(middlewares); i < j; i++ {
+		if middlewares[i] != nil {
+			dst = append(dst, middlewares[i])
+		}
+	}
+	return dst
+}
+
+// The composeMiddlewares function wraps the given handler with the given middlewares.
+// The first middleware is the outermost one, so it is executed first.
+func composeMiddlewares(h Handler, middlewares []Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// The sentContextKey type is the context key of the sent flag of the request.
+type sentContextKey struct{}
+
+// The sentFlag type records whether a request is sent by the HTTP client, so that the
+// errors returned by the client features before the request is sent (such as the rate
+// limiter, the bulkhead, the authenticators and the middlewares) are not considered as
+// the failures of the target host.
+type sentFlag struct {
+	sent   int32
+	parent *sentFlag
+}
+
+// The withSentFlag function returns a copy of the given request that records whether it
+// is sent by the HTTP client to the returned flag.
+func withSentFlag(req *http.Request) (*http.Request, *sentFlag) {
+	f := &sentFlag{}
+	f.parent, _ = req.Context().Value(sentContextKey{}).(*sentFlag)
+	return req.WithContext(context.WithValue(req.Context(), sentContextKey{}, f)), f
+}
+
+// The markSent function marks the given request and its outer requests as sent.
+func markSent(req *http.Request) {
+	f, _ := req.Context().Value(sentContextKey{}).(*sentFlag)
+	for ; f != nil; f = f.parent {
+		atomic.StoreInt32(&f.sent, 1)
+	}
+}
+
+// The isSent method determines whether the request is sent by the HTTP client.
+func (f *sentFlag) isSent() bool {
+	return atomic.LoadInt32(&f.sent) == 1
+}
+
+// The isNeutralResult function determines whether the given result of a request says
+// nothing about the target host, that is, the request is not sent by the HTTP client, or
+// it is cancelled (such as by the caller or by the hedging).
+func isNeutralResult(sent *sentFlag, err error) bool {
+	return !sent.isSent() || errors.Is(err, context.Canceled)
+}
+
+// The isHostFailure function determines whether the given result of a request sent by
+// the HTTP client is a failure of the target host. If the given predicate is not nil, it
+// is used, otherwise the transport errors (except context cancellation) and responses
+// with status 5xx are failures.
+func isHostFailure(predicate func(*http.Response, error) bool, o *http.Response, err error) bool {
+	if predicate != nil {
+		return predicate(o, err)
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return o.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("X-Common")+":"+r.Header.Get("X-Trace"))
+	}))
+	defer server.Close()
+
+	var trace []string
+	makeMiddleware := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(req *http.Request) (*http.Response, error) {
+				trace = append(trace, name)
+				req.Header.Set("X-Trace", strings.Join(trace, ","))
+				return next(req)
+			}
+		}
+	}
+
+	c := New().SetTimeout(time.Minute).SetCommonHeader("X-Common", "common")
+	if c.Use(makeMiddleware("a"), nil, makeMiddleware("b")) == nil {
+		t.Fatal("Client.Use() return nil")
+	}
+
+	req := c.New(server.URL)
+	if req.WithMiddleware(makeMiddleware("c")) == nil {
+		t.Fatal("Request.WithMiddleware() return nil")
+	}
+	res, err := req.Get()
+	if err != nil {
+		t.Fatalf("Request.Get() error: %s", err)
+	}
+	if got := res.String(); got != "common:a,b,c" {
+		t.Fatalf("Request.Get() got %q", got)
+	}
+}
+
+func TestMiddleware_ShortCircuit(t *testing.T) {
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	c := New().Use(func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusTeapot,
+				Status:     http.StatusText(http.StatusTeapot),
+				Header:     http.Header{"X-Synthetic": {"1"}},
+				Body:       ioutil.NopCloser(strings.NewReader("synthetic")),
+			}, nil
+		}
+	})
+
+	res, err := c.Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	if called {
+		t.Fatal("Client.Get() called the server")
+	}
+	if res.StatusCode() != http.StatusTeapot || res.String() != "synthetic" || res.Headers().Get("X-Synthetic") != "1" {
+		t.Fatalf("Client.Get() got %d %q", res.StatusCode(), res.String())
+	}
+
+	c = New().Use(func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusNoContent}, nil
+		}
+	})
+	if res, err := c.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	} else {
+		if res.StatusCode() != http.StatusNoContent || res.Len() != 0 {
+			t.Fatalf("Client.Get() got %d %q", res.StatusCode(), res.String())
+		}
+	}
+}
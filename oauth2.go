@@ -0,0 +1,294 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOAuth2ExpiryDelta is the default period before the token expiry in which the
+// token is considered expired and renewed.
+const DefaultOAuth2ExpiryDelta = 10 * time.Second
+
+// DefaultOAuth2Timeout is the default timeout of the token requests.
+const DefaultOAuth2Timeout = 30 * time.Second
+
+// OAuth2Options defines the OAuth2 authenticator.
+type OAuth2Options struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string
+
+	// ClientID is the client identifier.
+	ClientID string
+
+	// ClientSecret is the client secret.
+	ClientSecret string
+
+	// Scopes is the requested scopes.
+	Scopes []string
+
+	// RefreshToken enables the refresh token grant with the given initial refresh token.
+	// If it is empty, the client credentials grant is used.
+	RefreshToken string
+
+	// Params is the extra parameters sent to the token endpoint.
+	Params url.Values
+
+	// ClientAuthInBody sends the client credentials in the request body instead of the
+	// HTTP Basic authentication.
+	ClientAuthInBody bool
+
+	// ExpiryDelta is the period before the token expiry in which the token is renewed.
+	// If it is zero, DefaultOAuth2ExpiryDelta is used.
+	ExpiryDelta time.Duration
+
+	// Timeout is the timeout of the token request, which is shared by the concurrent
+	// requests and does not depend on the context of any of them.
+	// If it is zero, DefaultOAuth2Timeout is used.
+	Timeout time.Duration
+
+	// Client is the client used to request the token endpoint.
+	// If it is nil, a new built-in client is used.
+	Client Client
+}
+
+// OAuth2Error represents an error response of the token endpoint.
+type OAuth2Error struct {
+	// StatusCode is the status code of the token response.
+	StatusCode int
+
+	// Code is the "error" field of the token response.
+	Code string
+
+	// Description is the "error_description" field of the token response.
+	Description string
+}
+
+// Error returns the error message.
+func (e *OAuth2Error) Error() string {
+	s := fmt.Sprintf("oauth2: token request failed with status %d", e.StatusCode)
+	if e.Code != "" {
+		s += ": " + e.Code
+	}
+	if e.Description != "" {
+		s += ": " + e.Description
+	}
+	return s
+}
+
+// NewOAuth2Authenticator returns an authenticator that obtains bearer tokens from the
+// OAuth2 token endpoint with the client credentials or refresh token grant.
+// The token is cached until shortly before it expires, concurrent requests share a single
+// token request, and a request that receives a 401 response is retried once with a
+// new token.
+func NewOAuth2Authenticator(options *OAuth2Options) Authenticator {
+	a := &oauth2Authenticator{options: *options, refresh: options.RefreshToken}
+	if a.options.ExpiryDelta <= 0 {
+		a.options.ExpiryDelta = DefaultOAuth2ExpiryDelta
+	}
+	if a.options.Timeout <= 0 {
+		a.options.Timeout = DefaultOAuth2Timeout
+	}
+	if a.options.Client == nil {
+		a.options.Client = New()
+	}
+	return a
+}
+
+// The oauth2Authenticator type is a built-in implementation of the OAuth2 authentication.
+type oauth2Authenticator struct {
+	options OAuth2Options
+	mutex   sync.Mutex
+	token   string
+	kind    string
+	expiry  time.Time
+	refresh string
+	call    *oauth2Call
+}
+
+// The oauth2Call type represents an in-flight token request.
+type oauth2Call struct {
+	done  chan struct{}
+	token string
+	kind  string
+	err   error
+}
+
+// The oauth2Token type is the token response of the token endpoint.
+type oauth2Token struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        json64 `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Authenticate adds the credentials to the given HTTP request.
+func (a *oauth2Authenticator) Authenticate(req *http.Request) error {
+	token, kind, err := a.getToken(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", kind+" "+token)
+	return nil
+}
+
+// String returns the redacted description of the authenticator.
+func (a *oauth2Authenticator) String() string {
+	return "OAuth2 " + a.options.TokenURL + " " + a.options.ClientID + ":" + RedactedValue
+}
+
+// The challenge method invalidates the token used by the given request if the response
+// rejects it, and reports whether the request should be retried.
+func (a *oauth2Authenticator) challenge(req *http.Request, o *http.Response) bool {
+	if o.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// Other requests may have already renewed the token.
+	if a.token != "" && req.Header.Get("Authorization") == a.kind+" "+a.token {
+		a.token = ""
+	}
+	return true
+}
+
+// The getToken method returns the cached token, or requests a new token if the cached
+// token is expired. Only one token request is in flight at a time, it runs independently
+// of the given context, which only stops waiting for it.
+func (a *oauth2Authenticator) getToken(ctx context.Context) (string, string, error) {
+	a.mutex.Lock()
+	if a.token != "" && time.Now().Add(a.options.ExpiryDelta).Before(a.expiry) {
+		token, kind := a.token, a.kind
+		a.mutex.Unlock()
+		return token, kind, nil
+	}
+	call := a.call
+	if call == nil {
+		call = &oauth2Call{done: make(chan struct{})}
+		a.call = call
+		go a.fetch(call, a.refresh)
+	}
+	a.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.kind, call.err
+	case <-ctx.Done():
+		return "", "", ctx.Err()
+	}
+}
+
+// The fetch method requests a new token with the given refresh token, and completes the
+// given call with the result.
+func (a *oauth2Authenticator) fetch(call *oauth2Call, refresh string) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.options.Timeout)
+	defer cancel()
+
+	t, err := a.requestToken(ctx, refresh)
+
+	a.mutex.Lock()
+	if err == nil {
+		a.token, a.kind = t.AccessToken, t.TokenType
+		if t.ExpiresIn > 0 {
+			a.expiry = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+		} else {
+			// The token does not expire, or the server does not tell us.
+			a.expiry = time.Now().Add(100 * 365 * 24 * time.Hour)
+		}
+		if t.RefreshToken != "" {
+			a.refresh = t.RefreshToken
+		}
+		call.token, call.kind = a.token, a.kind
+	}
+	call.err = err
+	a.call = nil
+	a.mutex.Unlock()
+	close(call.done)
+}
+
+// The requestToken method requests a new token from the token endpoint.
+func (a *oauth2Authenticator) requestToken(ctx context.Context, refresh string) (*oauth2Token, error) {
+	form := make(url.Values, len(a.options.Params)+4)
+	for key, values := range a.options.Params {
+		form[key] = values
+	}
+	if refresh != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refresh)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(a.options.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.options.Scopes, " "))
+	}
+
+	req := a.options.Client.New(a.options.TokenURL).WithContext(ctx).WithHeader("Accept", "application/json")
+	if a.options.ClientAuthInBody {
+		form.Set("client_id", a.options.ClientID)
+		form.Set("client_secret", a.options.ClientSecret)
+		req.WithoutAuthenticator()
+	} else {
+		// RFC 6749 requires the client credentials to be form-urlencoded.
+		req.WithAuthenticator(NewBasicAuthenticator(url.QueryEscape(a.options.ClientID), url.QueryEscape(a.options.ClientSecret)))
+	}
+
+	res, err := req.WithFormBody(form).Post()
+	if err != nil {
+		return nil, err
+	}
+	t := new(oauth2Token)
+	if err := res.JSON(t); err != nil && res.StatusCode() < http.StatusBadRequest {
+		return nil, fmt.Errorf("oauth2: invalid token response: %w", err)
+	}
+	if res.StatusCode() < http.StatusOK || res.StatusCode() >= http.StatusMultipleChoices || t.Error != "" {
+		return nil, &OAuth2Error{StatusCode: res.StatusCode(), Code: t.Error, Description: t.ErrorDescription}
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("oauth2: token response has no access_token")
+	}
+	if t.TokenType == "" || strings.EqualFold(t.TokenType, "bearer") {
+		t.TokenType = "Bearer"
+	}
+	return t, nil
+}
+
+// The json64 type decodes a JSON number or numeric string, some servers send the
+// "expires_in" field as a string.
+type json64 int64
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (n *json64) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	var v int64
+	if _, err := fmt.Sscan(s, &v); err != nil {
+		return err
+	}
+	*n = json64(v)
+	return nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestTokenServer(t *testing.T, count *int32, expiresIn int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(count, 1)
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm() error: %s", err)
+		}
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if id != "id" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, `{"error":"invalid_client","error_description":"bad client"}`)
+			return
+		}
+		// Slow down the token request to test concurrent renewals.
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d-%s","token_type":"bearer","expires_in":"%d","refresh_token":"refresh-%d"}`,
+			n, r.PostForm.Get("grant_type")+r.PostForm.Get("refresh_token"), expiresIn, n)
+	}))
+}
+
+func TestOAuth2Authenticator(t *testing.T) {
+	var count int32
+	tokenServer := newTestTokenServer(t, &count, 3600)
+	defer tokenServer.Close()
+
+	var rejected int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Authorization") == "Bearer token-1-client_credentials" && atomic.LoadInt32(&rejected) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, r.Header.Get("Authorization")+"|"+string(body))
+	}))
+	defer server.Close()
+
+	c := New().SetAuthenticator(NewOAuth2Authenticator(&OAuth2Options{
+		TokenURL:     tokenServer.URL,
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Scopes:       []string{"a", "b"},
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Get(server.URL, nil)
+			if err != nil {
+				t.Errorf("Client.Get() error: %s", err)
+				return
+			}
+			if got := res.String(); got != "Bearer token-1-client_credentials|" {
+				t.Errorf("Client.Get() got %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Fatalf("OAuth2Authenticator requested %d tokens", n)
+	}
+
+	// The rejected token is renewed, and the request is sent again with the same body.
+	atomic.StoreInt32(&rejected, 1)
+	res, err := c.New(server.URL).WithBody(strings.NewReader("body")).Post()
+	if err != nil {
+		t.Fatalf("Request.Post() error: %s", err)
+	}
+	if got := res.String(); got != "Bearer token-2-refresh_tokenrefresh-1|body" {
+		t.Fatalf("Request.Post() got %q", got)
+	}
+}
+
+func TestOAuth2Authenticator_Expiry(t *testing.T) {
+	var count int32
+	tokenServer := newTestTokenServer(t, &count, 1)
+	defer tokenServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	a := NewOAuth2Authenticator(&OAuth2Options{
+		TokenURL:         tokenServer.URL,
+		ClientID:         "id",
+		ClientSecret:     "secret",
+		RefreshToken:     "initial",
+		ClientAuthInBody: true,
+	})
+	if s := fmt.Sprint(a); strings.Contains(s, "secret") {
+		t.Fatalf("OAuth2Authenticator string: %s", s)
+	}
+	c := New().SetAuthenticator(a)
+	for i, want := range []string{"Bearer token-1-refresh_tokeninitial", "Bearer token-2-refresh_tokenrefresh-1"} {
+		res, err := c.Get(server.URL, nil)
+		if err != nil {
+			t.Fatalf("[%d] Client.Get() error: %s", i, err)
+		}
+		if got := res.String(); got != want {
+			t.Fatalf("[%d] Client.Get() want %q got %q", i, want, got)
+		}
+	}
+}
+
+func TestOAuth2Authenticator_Cancel(t *testing.T) {
+	var count int32
+	requested := make(chan struct{}, 1)
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		requested <- struct{}{}
+		time.Sleep(100 * time.Millisecond)
+		_, _ = io.WriteString(w, `{"access_token":"token","token_type":"bearer"}`)
+	}))
+	defer tokenServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	c := New().SetAuthenticator(NewOAuth2Authenticator(&OAuth2Options{
+		TokenURL:     tokenServer.URL,
+		ClientID:     "id",
+		ClientSecret: "secret",
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.New(server.URL).WithContext(ctx).Get()
+		done <- err
+	}()
+	<-requested
+
+	// The deadline of the request that started the token request does not affect the
+	// other requests waiting for the token.
+	res, err := c.Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	if got := res.String(); got != "Bearer token" {
+		t.Fatalf("Client.Get() got %q", got)
+	}
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Client.Get() return error: %v", err)
+	}
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Fatalf("OAuth2Authenticator requested %d tokens", n)
+	}
+}
+
+func TestOAuth2Authenticator_Error(t *testing.T) {
+	var count int32
+	tokenServer := newTestTokenServer(t, &count, 3600)
+	defer tokenServer.Close()
+
+	c := New().SetAuthenticator(NewOAuth2Authenticator(&OAuth2Options{
+		TokenURL:     tokenServer.URL,
+		ClientID:     "id",
+		ClientSecret: "wrong",
+	}))
+	_, err := c.Get("http://127.0.0.1:1", nil)
+	var e *OAuth2Error
+	if !errors.As(err, &e) {
+		t.Fatalf("Client.Get() return error: %v", err)
+	}
+	if e.StatusCode != http.StatusUnauthorized || e.Code != "invalid_client" || e.Description != "bad client" {
+		t.Fatalf("Client.Get() return error: %+v", e)
+	}
+	if got := e.Error(); got != "oauth2: token request failed with status 401: invalid_client: bad client" {
+		t.Fatalf("OAuth2Error.Error() got %q", got)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidProxy is returned when the proxy configuration is invalid.
+var ErrInvalidProxy = errors.New("invalid proxy")
+
+// ProxyOptions defines the proxy of the requests.
+type ProxyOptions struct {
+	// URL is the proxy URL, the "http", "https" and "socks5" schemes are supported.
+	// If the scheme is omitted, "http" is used. The HTTPS requests are tunneled through
+	// the HTTP and HTTPS proxies by the CONNECT method.
+	// The username and password can be given in the URL, or by the fields below.
+	URL string
+
+	// Username and Password are the proxy credentials, they override the credentials
+	// in the URL. The HTTP and HTTPS proxies use the Basic authentication, and the SOCKS5
+	// proxy uses the username/password authentication.
+	Username string
+	Password string
+
+	// NoProxy is the hosts that bypass the proxy, like the NO_PROXY environment variable.
+	// Each item is one of the following:
+	//
+	//	"*"              bypass the proxy for all hosts
+	//	"example.com"    the host and its subdomains
+	//	".example.com"   only the subdomains of the host
+	//	"example.com:80" the host and its subdomains on the given port
+	//	"10.0.0.1"       the IP address
+	//	"10.0.0.0/8"     the IP addresses in the CIDR block
+	//
+	// Note that the loopback addresses are not bypassed unless they are listed.
+	NoProxy []string
+}
+
+// The proxyContextKey type is the context key of the proxy selected for the request.
+type proxyContextKey struct{}
+
+// The proxySelector type selects the proxy of the request URL.
+type proxySelector struct {
+	url     *url.URL
+	err     error
+	noProxy []noProxyRule
+}
+
+// The noProxyRule type is a parsed item of ProxyOptions.NoProxy.
+type noProxyRule struct {
+	all     bool
+	network *net.IPNet
+	ip      net.IP
+	domain  string
+	exact   bool
+	port    string
+}
+
+// The newProxySelector function creates a proxy selector from the given options.
+// If the options are invalid, the selector returns the error for every request, so that
+// the requests are never sent directly by mistake.
+func newProxySelector(options *ProxyOptions) *proxySelector {
+	p := new(proxySelector)
+	if p.url, p.err = parseProxyURL(options); p.err != nil {
+		return p
+	}
+	for _, item := range options.NoProxy {
+		for _, s := range strings.Split(item, ",") {
+			if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+				p.noProxy = append(p.noProxy, parseNoProxyRule(s))
+			}
+		}
+	}
+	return p
+}
+
+// The parseProxyURL function parses and validates the proxy URL of the given options.
+func parseProxyURL(options *ProxyOptions) (*url.URL, error) {
+	s := options.URL
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty proxy url", ErrInvalidProxy)
+	}
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProxy, err)
+	}
+	switch u.Scheme = strings.ToLower(u.Scheme); u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("%w: unsupported proxy scheme %q", ErrInvalidProxy, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: missing proxy host", ErrInvalidProxy)
+	}
+	if options.Username != "" || options.Password != "" {
+		u.User = url.UserPassword(options.Username, options.Password)
+	}
+	return u, nil
+}
+
+// The parseNoProxyRule function parses the given lowercase NoProxy item.
+func parseNoProxyRule(s string) noProxyRule {
+	if s == "*" {
+		return noProxyRule{all: true}
+	}
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return noProxyRule{network: network}
+	}
+	if ip := net.ParseIP(strings.Trim(s, "[]")); ip != nil {
+		return noProxyRule{ip: ip}
+	}
+	var rule noProxyRule
+	if host, port, err := net.SplitHostPort(s); err == nil {
+		s, rule.port = host, port
+		if ip := net.ParseIP(s); ip != nil {
+			rule.ip = ip
+			return rule
+		}
+	}
+	if strings.HasPrefix(s, "*.") {
+		s = s[1:]
+	}
+	if strings.HasPrefix(s, ".") {
+		rule.domain = s
+	} else {
+		rule.domain, rule.exact = "."+s, true
+	}
+	return rule
+}
+
+// The match method determines whether the rule matches the given lowercase host and port.
+func (rule noProxyRule) match(host, port string, ip net.IP) bool {
+	if rule.all {
+		return true
+	}
+	if rule.port != "" && rule.port != port {
+		return false
+	}
+	switch {
+	case rule.network != nil:
+		return ip != nil && rule.network.Contains(ip)
+	case rule.ip != nil:
+		return ip != nil && rule.ip.Equal(ip)
+	case ip != nil:
+		return false
+	}
+	return strings.HasSuffix(host, rule.domain) || (rule.exact && host == rule.domain[1:])
+}
+
+// The proxy method returns the proxy URL of the given request URL, or nil if the request
+// should be sent directly.
+func (p *proxySelector) proxy(u *url.URL) (*url.URL, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(p.noProxy) > 0 {
+		host, port := strings.ToLower(u.Hostname()), u.Port()
+		if port == "" {
+			switch u.Scheme {
+			case "http":
+				port = "80"
+			case "https":
+				port = "443"
+			}
+		}
+		ip := net.ParseIP(host)
+		for _, rule := range p.noProxy {
+			if rule.match(host, port, ip) {
+				return nil, nil
+			}
+		}
+	}
+	return p.url, nil
+}
+
+// The proxyFromContext function returns the proxy function of the derived transport.
+// The proxy selected for the request (by the request or the client) is carried by the
+// request context, and the proxy function of the original transport is used when there
+// is no proxy in the context.
+func proxyFromContext(fallback func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if v := req.Context().Value(proxyContextKey{}); v != nil {
+			if p := v.(*proxySelector); p != nil {
+				return p.proxy(req.URL)
+			}
+			// The proxy is disabled by the request.
+			return nil, nil
+		}
+		if fallback != nil {
+			return fallback(req)
+		}
+		return nil, nil
+	}
+}
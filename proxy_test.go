@@ -0,0 +1,313 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestHTTPProxy(t *testing.T, count *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(count, 1)
+		user, pass, _ := (&http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}).BasicAuth()
+		if user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != http.MethodConnect {
+			_, _ = io.WriteString(w, "proxy:"+r.URL.String())
+			return
+		}
+		dst, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		src, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack(): %s", err)
+			return
+		}
+		_, _ = io.WriteString(src, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		go func() { _, _ = io.Copy(dst, src); _ = dst.Close() }()
+		_, _ = io.Copy(src, dst)
+		_ = src.Close()
+	}))
+}
+
+// The newTestSOCKS5Proxy function starts a minimal SOCKS5 server with the username/password
+// authentication (RFC 1928 and RFC 1929), it only supports the CONNECT command.
+func newTestSOCKS5Proxy(t *testing.T, count *int32) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %s", err)
+	}
+	serve := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+		atomic.AddInt32(count, 1)
+
+		buf := make([]byte, 512)
+		// Greeting: VER NMETHODS METHODS.
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte{5, 2})
+		// Authentication: VER ULEN UNAME PLEN PASSWD.
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, user); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		pass := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, pass); err != nil {
+			return
+		}
+		if string(user) != "user" || string(pass) != "pass" {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+		// Request: VER CMD RSV ATYP DST.ADDR DST.PORT.
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return
+		}
+		var host string
+		switch buf[3] {
+		case 1:
+			if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+				return
+			}
+			host = net.IP(buf[:4]).String()
+		case 3:
+			if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+				return
+			}
+			name := make([]byte, buf[0])
+			if _, err := io.ReadFull(conn, name); err != nil {
+				return
+			}
+			host = string(name)
+		default:
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		port := binary.BigEndian.Uint16(buf[:2])
+		dst, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer func() { _ = dst.Close() }()
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		go func() { _, _ = io.Copy(dst, conn) }()
+		_, _ = io.Copy(conn, dst)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln
+}
+
+func TestClient_SetProxy(t *testing.T) {
+	var count int32
+	proxy := newTestHTTPProxy(t, &count)
+	defer proxy.Close()
+
+	c := New().SetProxy(&ProxyOptions{URL: proxy.URL, Username: "user", Password: "pass"})
+	res, err := c.New("http://example.invalid/a?b=c").Get()
+	if err != nil {
+		t.Fatalf("Client.SetProxy(): %s", err)
+	}
+	if got := res.String(); got != "proxy:http://example.invalid/a?b=c" {
+		t.Fatalf("Client.SetProxy(): %s", got)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "direct")
+	}))
+	defer server.Close()
+
+	// Bypass the proxy.
+	c.SetProxy(&ProxyOptions{URL: proxy.URL, NoProxy: []string{"example.com, 127.0.0.0/8"}})
+	if res, err = c.New(server.URL).Get(); err != nil {
+		t.Fatalf("Client.SetProxy(): %s", err)
+	}
+	if got := res.String(); got != "direct" {
+		t.Fatalf("Client.SetProxy(): %s", got)
+	}
+
+	// The request proxy overrides the client proxy.
+	res, err = c.New(server.URL).WithProxy(&ProxyOptions{URL: proxy.URL, Username: "user", Password: "pass"}).Get()
+	if err != nil {
+		t.Fatalf("Request.WithProxy(): %s", err)
+	}
+	if got := res.String(); got != "proxy:"+server.URL+"/" {
+		t.Fatalf("Request.WithProxy(): %s", got)
+	}
+
+	c.SetProxy(&ProxyOptions{URL: proxy.URL, Username: "user", Password: "pass"})
+	if res, err = c.New(server.URL).WithoutProxy().Get(); err != nil {
+		t.Fatalf("Request.WithoutProxy(): %s", err)
+	}
+	if got := res.String(); got != "direct" {
+		t.Fatalf("Request.WithoutProxy(): %s", got)
+	}
+	if res, err = c.New(server.URL).WithProxy(nil).Get(); err != nil {
+		t.Fatalf("Request.WithProxy(): %s", err)
+	}
+	if got := res.String(); got != "proxy:"+server.URL+"/" {
+		t.Fatalf("Request.WithProxy(): %s", got)
+	}
+
+	if res, err = c.SetProxy(nil).New(server.URL).Get(); err != nil {
+		t.Fatalf("Client.SetProxy(): %s", err)
+	}
+	if got := res.String(); got != "direct" {
+		t.Fatalf("Client.SetProxy(): %s", got)
+	}
+}
+
+func TestClient_SetProxy_Connect(t *testing.T) {
+	var count int32
+	proxy := newTestHTTPProxy(t, &count)
+	defer proxy.Close()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "tls")
+	}))
+	defer server.Close()
+
+	c := New().SetHTTPClient(server.Client()).SetProxy(&ProxyOptions{URL: "user:pass@" + proxy.Listener.Addr().String()})
+	res, err := c.New(server.URL).Get()
+	if err != nil {
+		t.Fatalf("Client.SetProxy(): %s", err)
+	}
+	if got := res.String(); got != "tls" {
+		t.Fatalf("Client.SetProxy(): %s", got)
+	}
+	if got := atomic.LoadInt32(&count); got != 1 {
+		t.Fatalf("Client.SetProxy(): proxy count %d", got)
+	}
+}
+
+func TestClient_SetProxy_SOCKS5(t *testing.T) {
+	var count int32
+	proxy := newTestSOCKS5Proxy(t, &count)
+	defer func() { _ = proxy.Close() }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "socks5")
+	}))
+	defer server.Close()
+
+	c := New().SetProxy(&ProxyOptions{URL: "socks5://user:pass@" + proxy.Addr().String()})
+	res, err := c.New(server.URL).Get()
+	if err != nil {
+		t.Fatalf("Client.SetProxy(): %s", err)
+	}
+	if got := res.String(); got != "socks5" {
+		t.Fatalf("Client.SetProxy(): %s", got)
+	}
+	if got := atomic.LoadInt32(&count); got != 1 {
+		t.Fatalf("Client.SetProxy(): proxy count %d", got)
+	}
+
+	c.SetProxy(&ProxyOptions{URL: "socks5://" + proxy.Addr().String(), Username: "user", Password: "wrong"})
+	if _, err := c.New(server.URL).Get(); err == nil {
+		t.Fatal("Client.SetProxy(): nil error")
+	}
+}
+
+func TestClient_SetProxy_Invalid(t *testing.T) {
+	items := []*ProxyOptions{
+		{},
+		{URL: "ftp://127.0.0.1"},
+		{URL: "http://"},
+		{URL: "http://[::1"},
+	}
+	for i, item := range items {
+		_, err := New().SetProxy(item).New("http://127.0.0.1/").Get()
+		if !errors.Is(err, ErrInvalidProxy) {
+			t.Fatalf("Client.SetProxy(): %d %v", i, err)
+		}
+	}
+}
+
+func TestProxySelector(t *testing.T) {
+	p := newProxySelector(&ProxyOptions{
+		URL:     "proxy:3128",
+		NoProxy: []string{"example.com", ".internal", "*.corp.net", "api.test:8443", "10.0.0.0/8", "[::1]:8080", "192.168.1.1"},
+	})
+	items := []struct {
+		URL   string
+		Proxy bool
+	}{
+		{"http://example.com/", false},
+		{"http://www.example.com/", false},
+		{"http://notexample.com/", true},
+		{"http://internal/", true},
+		{"http://a.internal/", false},
+		{"http://a.corp.net/", false},
+		{"http://corp.net/", true},
+		{"https://api.test:8443/", false},
+		{"https://api.test/", true},
+		{"http://10.1.2.3/", false},
+		{"http://11.1.2.3/", true},
+		{"http://[::1]:8080/", false},
+		{"http://[::1]/", true},
+		{"http://192.168.1.1:9000/", false},
+	}
+	for i, item := range items {
+		got, err := p.proxy(mustParseURL(t, item.URL))
+		if err != nil {
+			t.Fatalf("proxySelector.proxy(): %d %s", i, err)
+		}
+		if (got != nil) != item.Proxy {
+			t.Fatalf("proxySelector.proxy(): %d %s: %v", i, item.URL, got)
+		}
+		if got != nil && got.String() != "http://proxy:3128" {
+			t.Fatalf("proxySelector.proxy(): %d %s", i, got)
+		}
+	}
+
+	p = newProxySelector(&ProxyOptions{URL: "https://proxy", NoProxy: []string{"*"}})
+	if got, err := p.proxy(mustParseURL(t, "http://example.com/")); got != nil || err != nil {
+		t.Fatalf("proxySelector.proxy(): %v %v", got, err)
+	}
+}
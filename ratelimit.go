@@ -0,0 +1,339 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited represents a rate limit error.
+// When sending a request in the RateLimitFail mode, this error is returned if the rate
+// limit of the client or the target host is exceeded.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitMode defines the behavior of the rate limiter when the limit is exceeded.
+type RateLimitMode int
+
+// These are the rate limiter modes.
+const (
+	// RateLimitWait mode blocks the request until it is allowed or the request context is done.
+	RateLimitWait RateLimitMode = iota
+	// RateLimitFail mode fails the request immediately with ErrRateLimited.
+	RateLimitFail
+)
+
+// RateLimit defines a token bucket.
+type RateLimit struct {
+	// Rate is the number of tokens added to the bucket per second.
+	Rate float64
+
+	// Burst is the capacity of the bucket.
+	// If it is zero, the rate (at least 1) is used.
+	Burst int
+}
+
+// RateLimiterOptions defines the rate limiter of the client.
+type RateLimiterOptions struct {
+	// Global is the rate limit shared by all requests of the client.
+	// If it is nil, there is no global rate limit.
+	Global *RateLimit
+
+	// Hosts is the rate limits of hosts, keyed by host pattern.
+	// A pattern is an exact host name (optionally with port), a wildcard domain such as
+	// "*.example.com", or "*" for all hosts. Each host has its own token bucket, which is
+	// configured by the exact pattern first, then by the longest matching wildcard pattern.
+	Hosts map[string]*RateLimit
+
+	// Mode is the behavior when the limit is exceeded.
+	Mode RateLimitMode
+
+	// Adaptive enables adapting to the quota announced by the server in the response headers
+	// "X-RateLimit-Remaining" / "X-RateLimit-Reset" or "RateLimit-Remaining" / "RateLimit-Reset".
+	// When the announced quota is exhausted, requests to the host are limited until the
+	// quota is reset.
+	Adaptive bool
+}
+
+// The rateLimiter type is the rate limiter of the client.
+type rateLimiter struct {
+	options RateLimiterOptions
+	global  *tokenBucket
+	mutex   sync.Mutex
+	hosts   map[string]*hostRateLimiter
+}
+
+// The hostRateLimiter type holds the rate limit state of a single host.
+type hostRateLimiter struct {
+	bucket *tokenBucket
+	quota  *serverQuota
+}
+
+// The newRateLimiter function creates a rate limiter from the given options.
+func newRateLimiter(options *RateLimiterOptions) *rateLimiter {
+	l := &rateLimiter{options: *options, hosts: make(map[string]*hostRateLimiter)}
+	if options.Global != nil {
+		l.global = newTokenBucket(options.Global)
+	}
+	return l
+}
+
+// The middleware method returns the middleware that limits the request rate.
+func (l *rateLimiter) middleware(next Handler) Handler {
+	return func(req *http.Request) (*http.Response, error) {
+		host := l.host(req.URL.Host)
+		if err := l.wait(req.Context(), req.URL.Host, host); err != nil {
+			return nil, err
+		}
+		o, err := next(req)
+		if err == nil && host.quota != nil {
+			host.quota.update(o.Header, time.Now())
+		}
+		return o, err
+	}
+}
+
+// The host method returns the rate limit state of the given host.
+func (l *rateLimiter) host(name string) *hostRateLimiter {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	h := l.hosts[name]
+	if h == nil {
+		h = new(hostRateLimiter)
+		if limit := matchHostRateLimit(l.options.Hosts, name); limit != nil {
+			h.bucket = newTokenBucket(limit)
+		}
+		if l.options.Adaptive {
+			h.quota = new(serverQuota)
+		}
+		l.hosts[name] = h
+	}
+	return h
+}
+
+// The wait method acquires the permission to send a request to the given host.
+func (l *rateLimiter) wait(ctx context.Context, name string, host *hostRateLimiter) error {
+	if host.quota != nil {
+		if err := l.delay(ctx, name, host.quota.reserve(time.Now())); err != nil {
+			return err
+		}
+	}
+	if host.bucket != nil {
+		if err := l.take(ctx, name, host.bucket); err != nil {
+			return err
+		}
+	}
+	if l.global != nil {
+		if err := l.take(ctx, name, l.global); err != nil {
+			if host.bucket != nil {
+				host.bucket.refund()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// The take method takes a token from the given bucket.
+func (l *rateLimiter) take(ctx context.Context, name string, b *tokenBucket) error {
+	if l.options.Mode == RateLimitFail {
+		if !b.allow(time.Now()) {
+			return fmt.Errorf("%w: %s", ErrRateLimited, name)
+		}
+		return nil
+	}
+	if err := l.delay(ctx, name, b.reserve(time.Now())); err != nil {
+		b.refund()
+		return err
+	}
+	return nil
+}
+
+// The delay method waits for the given duration according to the limiter mode.
+func (l *rateLimiter) delay(ctx context.Context, name string, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	if l.options.Mode == RateLimitFail {
+		return fmt.Errorf("%w: %s", ErrRateLimited, name)
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return fmt.Errorf("%w: %s: %s", ErrRateLimited, name, context.DeadlineExceeded)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// The matchHostRateLimit function finds the rate limit of the given host.
+func matchHostRateLimit(limits map[string]*RateLimit, host string) *RateLimit {
+	if len(limits) == 0 {
+		return nil
+	}
+	if limit, found := limits[host]; found {
+		return limit
+	}
+	name := host
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		name = host[:i]
+		if limit, found := limits[name]; found {
+			return limit
+		}
+	}
+	var match *RateLimit
+	var size int
+	for pattern, limit := range limits {
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(name, pattern[1:]) && len(pattern) > size {
+			match, size = limit, len(pattern)
+		}
+	}
+	if match == nil {
+		match = limits["*"]
+	}
+	return match
+}
+
+// The tokenBucket type is a simple token bucket.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// The newTokenBucket function creates a full token bucket from the given rate limit.
+func newTokenBucket(limit *RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// The advance method adds the tokens generated since the last update.
+func (b *tokenBucket) advance(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// The allow method takes a token if it is available.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.advance(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// The reserve method takes a token and returns the time to wait until it is available.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.advance(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	if b.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// The refund method returns a token to the bucket.
+func (b *tokenBucket) refund() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// The serverQuota type tracks the quota announced by the server.
+type serverQuota struct {
+	mutex     sync.Mutex
+	remaining int64
+	reset     time.Time
+}
+
+// The reserve method takes a request from the quota and returns the time to wait until
+// the quota is reset if it is exhausted.
+func (q *serverQuota) reserve(now time.Time) time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.reset.IsZero() || !now.Before(q.reset) {
+		return 0
+	}
+	if q.remaining > 0 {
+		q.remaining--
+		return 0
+	}
+	return q.reset.Sub(now)
+}
+
+// The update method updates the quota from the given response headers.
+func (q *serverQuota) update(h http.Header, now time.Time) {
+	remaining, reset, ok := parseRateLimitHeaders(h, now)
+	if !ok {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.remaining, q.reset = remaining, reset
+}
+
+// The parseRateLimitHeaders function parses the remaining quota and the reset time from
+// the given response headers.
+func parseRateLimitHeaders(h http.Header, now time.Time) (int64, time.Time, bool) {
+	for _, prefix := range []string{"X-Ratelimit-", "Ratelimit-"} {
+		remaining, err := strconv.ParseInt(strings.TrimSpace(h.Get(prefix+"Remaining")), 10, 64)
+		if err != nil {
+			continue
+		}
+		reset, err := strconv.ParseInt(strings.TrimSpace(h.Get(prefix+"Reset")), 10, 64)
+		if err != nil || reset < 0 {
+			continue
+		}
+		// The reset value is either a number of seconds or a unix timestamp, large values
+		// can only be timestamps.
+		if reset > 1e9 {
+			return remaining, time.Unix(reset, 0), true
+		}
+		return remaining, now.Add(time.Duration(reset) * time.Second), true
+	}
+	return 0, time.Time{}, false
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClient_SetRateLimiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	c := New()
+	options := &RateLimiterOptions{
+		Global: &RateLimit{Rate: 1000},
+		Hosts:  map[string]*RateLimit{"127.0.0.1": {Rate: 1, Burst: 2}},
+		Mode:   RateLimitFail,
+	}
+	if c.SetRateLimiter(options) == nil {
+		t.Fatal("Client.SetRateLimiter() return nil")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(server.URL, nil); err != nil {
+			t.Fatalf("Client.Get() error: %s", err)
+		}
+	}
+	if _, err := c.Get(server.URL, nil); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Client.Get() return error: %v", err)
+	}
+
+	options = &RateLimiterOptions{Hosts: map[string]*RateLimit{"*": {Rate: 50, Burst: 1}}}
+	c.SetRateLimiter(options)
+	begin := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(server.URL, nil); err != nil {
+			t.Fatalf("Client.Get() error: %s", err)
+		}
+	}
+	if d := time.Since(begin); d < 35*time.Millisecond {
+		t.Fatalf("Client.Get() was not limited: %s", d)
+	}
+
+	// The waiting time exceeds the request deadline.
+	c.SetRateLimiter(&RateLimiterOptions{Global: &RateLimit{Rate: 0.1, Burst: 1}})
+	_, _ = c.Get(server.URL, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.New(server.URL).WithContext(ctx).Get(); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Request.Get() return error: %v", err)
+	}
+
+	if c.SetRateLimiter(nil) == nil {
+		t.Fatal("Client.SetRateLimiter(nil) return nil")
+	}
+}
+
+func TestRateLimiter_Adaptive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", "60")
+	}))
+	defer server.Close()
+
+	c := New().SetRateLimiter(&RateLimiterOptions{Mode: RateLimitFail, Adaptive: true})
+	if _, err := c.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	if _, err := c.Get(server.URL, nil); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Client.Get() return error: %v", err)
+	}
+}
+
+func TestMatchHostRateLimit(t *testing.T) {
+	a, b, c, d := &RateLimit{}, &RateLimit{}, &RateLimit{}, &RateLimit{}
+	limits := map[string]*RateLimit{
+		"example.com:8080":  a,
+		"*.example.com":     b,
+		"*.api.example.com": c,
+		"*":                 d,
+	}
+	items := map[string]*RateLimit{
+		"example.com:8080":   a,
+		"example.com":        d,
+		"www.example.com":    b,
+		"v1.api.example.com": c,
+		"other.com:80":       d,
+	}
+	for host, want := range items {
+		if got := matchHostRateLimit(limits, host); got != want {
+			t.Fatalf("matchHostRateLimit(%q) returned unexpected rate limit", host)
+		}
+	}
+	if matchHostRateLimit(nil, "example.com") != nil {
+		t.Fatal("matchHostRateLimit() with nil limits return non-nil")
+	}
+}
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	items := []struct {
+		Give      http.Header
+		Remaining int64
+		Reset     time.Time
+		OK        bool
+	}{
+		{http.Header{}, 0, time.Time{}, false},
+		{http.Header{"X-Ratelimit-Remaining": {"5"}, "X-Ratelimit-Reset": {"30"}}, 5, now.Add(30 * time.Second), true},
+		{http.Header{"X-Ratelimit-Remaining": {"5"}, "X-Ratelimit-Reset": {strconv.Itoa(1600000100)}}, 5, now.Add(100 * time.Second), true},
+		{http.Header{"Ratelimit-Remaining": {"1"}, "Ratelimit-Reset": {"10"}}, 1, now.Add(10 * time.Second), true},
+		{http.Header{"Ratelimit-Remaining": {"1"}, "Ratelimit-Reset": {"foo"}}, 0, time.Time{}, false},
+	}
+	for i, item := range items {
+		remaining, reset, ok := parseRateLimitHeaders(item.Give, now)
+		if remaining != item.Remaining || !reset.Equal(item.Reset) || ok != item.OK {
+			t.Fatalf("parseRateLimitHeaders() [%d] got %d %s %v", i, remaining, reset, ok)
+		}
+	}
+}
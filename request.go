@@ -23,11 +23,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -37,7 +39,8 @@ import (
 
 var (
 	// ErrEmptyRequestURL represents an empty request url error.
-	// When sending a request, this error will be returned if the given request url is empty.
+	// When sending a request, this error will be returned if the given request url is empty
+	// and the client has no base URL or load balancer.
 	ErrEmptyRequestURL = errors.New("empty request url")
 
 	// ErrInvalidRequestBody indicates an invalid request body error.
@@ -51,8 +54,21 @@ var (
 	// ErrInvalidUploadBody indicates an invalid upload body error.
 	// When sending an upload request, this error is returned when the bound upload data is invalid.
 	ErrInvalidUploadBody = errors.New("invalid upload body")
+
+	// ErrMissingPathParam represents a missing path parameter error.
+	// When sending a request, this error is returned when a placeholder in the request url
+	// has no corresponding path parameter.
+	ErrMissingPathParam = errors.New("missing path parameter")
+
+	// ErrUnusedPathParam represents an unused path parameter error.
+	// When sending a request, this error is returned when a given path parameter has no
+	// corresponding placeholder in the request url.
+	ErrUnusedPathParam = errors.New("unused path parameter")
 )
 
+// The pathParamPattern matches the "{name}" placeholders in the request url.
+var pathParamPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_.-]*)\}`)
+
 // Request interface defines client requests.
 type Request interface {
 	// WithMethod adds the default request method of the current request.
@@ -90,10 +106,69 @@ type Request interface {
 	// WithQueries adds and replaces some query parameters to the current request.
 	WithQueries(url.Values) Request
 
+	// WithoutQuery removes the given common query parameters of the client from the
+	// current request. The query parameters of the request url are not affected.
+	WithoutQuery(...string) Request
+
 	// WithTimeout adds a timeout for the current request.
 	// If the given timeout period is zero, the client's timeout setting is used.
 	WithTimeout(time.Duration) Request
 
+	// WithPathParam adds a path parameter to the current request.
+	// The "{name}" placeholder in the request url is replaced by the escaped parameter value
+	// when the request is sent. This method will automatically convert the given parameter
+	// value to a string.
+	WithPathParam(string, interface{}) Request
+
+	// WithPathParams adds some path parameters to the current request.
+	WithPathParams(map[string]interface{}) Request
+
+	// WithMiddleware adds the given middlewares to the current request.
+	// The request middlewares are executed in the order they are added, and after the
+	// middlewares of the client.
+	WithMiddleware(...Middleware) Request
+
+	// WithRetry adds a retry policy for the current request.
+	// If the given retry policy is nil, the client's retry policy is used.
+	// To disable retrying for the current request, give a policy with zero MaxAttempts.
+	WithRetry(*RetryPolicy) Request
+
+	// WithHedging enables the hedging of the current request, which is only applied to
+	// the idempotent requests. If there is no response after the given delay, a duplicate
+	// attempt is sent, up to the given number of hedges. The first successful response
+	// (without error and with a status code less than 500) wins, and the other attempts
+	// are cancelled. The Response.HedgedAttempt method reports which attempt won.
+	// If the delay or the number of hedges is not positive, the hedging is disabled.
+	WithHedging(time.Duration, int) Request
+
+	// WithTimings enables capturing the timings of the current request, such as the DNS
+	// lookup, the connection and the time to first byte, which are reported by the
+	// Response.Timings method. See Client.SetTimings to enable it for all requests.
+	WithTimings() Request
+
+	// WithAuthenticator adds an authenticator for the current request.
+	// If the given authenticator is nil, the client's authenticator is used.
+	WithAuthenticator(Authenticator) Request
+
+	// WithoutAuthenticator disables the authentication of the current request.
+	WithoutAuthenticator() Request
+
+	// WithSigner adds a request signer for the current request.
+	// If the given signer is nil, the client's signer is used.
+	WithSigner(Signer) Request
+
+	// WithCookie adds a cookie for the current request.
+	// The cookie is sent in addition to the cookies of the client's cookie jar.
+	WithCookie(*http.Cookie) Request
+
+	// WithProxy adds a proxy for the current request, which overrides the client's proxy.
+	// If the given options are nil, the client's proxy is used.
+	WithProxy(*ProxyOptions) Request
+
+	// WithoutProxy disables the proxy of the current request, including the proxy
+	// of the client and the proxy from the environment.
+	WithoutProxy() Request
+
 	// WithBody adds request body to the current request.
 	WithBody(interface{}) Request
 
@@ -183,13 +258,27 @@ type Request interface {
 // The request type is a built-in implementation of the Request interface.
 type request struct {
 	client       *client
+	config       *clientConfig
 	uri          string
 	method       string
 	headers      http.Header
 	ctx          context.Context
 	query        url.Values
+	noQuery      []string
+	pathParams   map[string]string
 	timeout      time.Duration
 	responder    Responder
+	middlewares  []Middleware
+	retry        *RetryPolicy
+	hedgeDelay   time.Duration
+	hedges       int
+	timings      bool
+	auth         Authenticator
+	noAuth       bool
+	signer       Signer
+	cookies      []*http.Cookie
+	proxy        *proxySelector
+	noProxy      bool
 	body         interface{}
 	bodyFormData map[string][]*formDataValue
 	bodyEncoder  string
@@ -277,6 +366,13 @@ func (r *request) WithQueries(qs url.Values) Request {
 	return r
 }
 
+// WithoutQuery removes the given common query parameters of the client from the
+// current request. The query parameters of the request url are not affected.
+func (r *request) WithoutQuery(keys ...string) Request {
+	r.noQuery = append(r.noQuery, keys...)
+	return r
+}
+
 // WithTimeout adds a timeout for the current request.
 // If the given timeout period is zero, the client's timeout setting is used.
 func (r *request) WithTimeout(t time.Duration) Request {
@@ -284,6 +380,112 @@ func (r *request) WithTimeout(t time.Duration) Request {
 	return r
 }
 
+// WithPathParam adds a path parameter to the current request.
+// The "{name}" placeholder in the request url is replaced by the escaped parameter value
+// when the request is sent. This method will automatically convert the given parameter
+// value to a string.
+func (r *request) WithPathParam(name string, value interface{}) Request {
+	if r.pathParams == nil {
+		r.pathParams = make(map[string]string, 1)
+	}
+	r.pathParams[name] = internal.ToString(value)
+	return r
+}
+
+// WithPathParams adds some path parameters to the current request.
+func (r *request) WithPathParams(params map[string]interface{}) Request {
+	for name, value := range params {
+		r.WithPathParam(name, value)
+	}
+	return r
+}
+
+// WithMiddleware adds the given middlewares to the current request.
+// The request middlewares are executed in the order they are added, and after the
+// middlewares of the client.
+func (r *request) WithMiddleware(middlewares ...Middleware) Request {
+	r.middlewares = appendMiddlewares(r.middlewares, middlewares)
+	return r
+}
+
+// WithRetry adds a retry policy for the current request.
+// If the given retry policy is nil, the client's retry policy is used.
+// To disable retrying for the current request, give a policy with zero MaxAttempts.
+func (r *request) WithRetry(policy *RetryPolicy) Request {
+	r.retry = policy
+	return r
+}
+
+// WithHedging enables the hedging of the current request, which is only applied to
+// the idempotent requests. If there is no response after the given delay, a duplicate
+// attempt is sent, up to the given number of hedges. The first successful response
+// (without error and with a status code less than 500) wins, and the other attempts
+// are cancelled. The Response.HedgedAttempt method reports which attempt won.
+// If the delay or the number of hedges is not positive, the hedging is disabled.
+func (r *request) WithHedging(delay time.Duration, hedges int) Request {
+	r.hedgeDelay = delay
+	r.hedges = hedges
+	return r
+}
+
+// WithTimings enables capturing the timings of the current request, such as the DNS
+// lookup, the connection and the time to first byte, which are reported by the
+// Response.Timings method. See Client.SetTimings to enable it for all requests.
+func (r *request) WithTimings() Request {
+	r.timings = true
+	return r
+}
+
+// WithAuthenticator adds an authenticator for the current request.
+// If the given authenticator is nil, the client's authenticator is used.
+func (r *request) WithAuthenticator(auth Authenticator) Request {
+	r.auth = auth
+	r.noAuth = false
+	return r
+}
+
+// WithoutAuthenticator disables the authentication of the current request.
+func (r *request) WithoutAuthenticator() Request {
+	r.auth = nil
+	r.noAuth = true
+	return r
+}
+
+// WithSigner adds a request signer for the current request.
+// If the given signer is nil, the client's signer is used.
+func (r *request) WithSigner(signer Signer) Request {
+	r.signer = signer
+	return r
+}
+
+// WithCookie adds a cookie for the current request.
+// The cookie is sent in addition to the cookies of the client's cookie jar.
+func (r *request) WithCookie(cookie *http.Cookie) Request {
+	if cookie != nil {
+		r.cookies = append(r.cookies, cookie)
+	}
+	return r
+}
+
+// WithProxy adds a proxy for the current request, which overrides the client's proxy.
+// If the given options are nil, the client's proxy is used.
+func (r *request) WithProxy(options *ProxyOptions) Request {
+	r.proxy = nil
+	r.noProxy = false
+	if options != nil {
+		r.proxy = newProxySelector(options)
+	}
+	return r
+}
+
+// WithoutProxy disables the proxy of the current request, including the proxy
+// of the client and the proxy from the environment.
+func (r *request) WithoutProxy() Request {
+	r.proxy = nil
+	r.noProxy = true
+	return r
+}
+
 // WithBody adds request body to the current request.
 func (r *request) WithBody(body interface{}) Request {
 	r.body = body
@@ -373,16 +575,15 @@ func (r *request) Send() (Response, error) {
 // SendBy sends the current request and returns the received response.
 // This method will send the request using the given request method.
 func (r *request) SendBy(method string) (Response, error) {
-	if r.uri == "" {
+	// The configuration of the client is captured once for the whole request.
+	r.config = r.client.load()
+	if r.uri == "" && r.config.base() == "" && r.config.balancer == nil {
 		return nil, ErrEmptyRequestURL
 	}
 
-	if o, err := r.send(strings.ToUpper(method)); err != nil {
-		return nil, err
-	} else {
-		// For HEAD requests, we ignore the response body.
-		return r.fromResponder(o, o.Request.Method == http.MethodHead)
-	}
+	method = strings.ToUpper(method)
+	// For HEAD requests, we ignore the response body.
+	return r.send(method, method == http.MethodHead)
 }
 
 // Build the Response instance from the responder.
@@ -390,14 +591,15 @@ func (r *request) fromResponder(o *http.Response, noBody bool) (Response, error)
 	if r.responder != nil {
 		return r.responder(o, noBody)
 	}
-	if r.client.responder != nil {
-		return r.client.responder(o, noBody)
+	if r.config.responder != nil {
+		return r.config.responder(o, noBody)
 	}
 	return NewResponse(o, noBody)
 }
 
-// The send method sends the current request and returns the received response.
-func (r *request) send(method string) (*http.Response, error) {
+// The send method sends the current request and builds the Response instance from the
+// received response.
+func (r *request) send(method string, noBody bool) (Response, error) {
 	body, err := r.makeBodyReader()
 	if err != nil {
 		return nil, err
@@ -409,15 +611,40 @@ func (r *request) send(method string) (*http.Response, error) {
 		// so that the resources of this request can be released quickly.
 		defer cancel()
 	}
+	// The transport selects the proxy of the request from the context, the proxy of the
+	// request takes precedence over the proxy of the client.
+	if r.proxy != nil || r.noProxy {
+		ctx = context.WithValue(ctx, proxyContextKey{}, r.proxy)
+	} else if r.config.proxy != nil {
+		ctx = context.WithValue(ctx, proxyContextKey{}, r.config.proxy)
+	}
 
-	req, err := http.NewRequestWithContext(ctx, method, r.uri, body)
+	uri, err := r.expandPathParams()
 	if err != nil {
 		return nil, err
 	}
-	// Add common request headers provided by the client.
-	if len(r.client.headers) > 0 {
-		for key, values := range r.client.headers {
-			req.Header[key] = values
+	// The relative request uris are resolved against the endpoints of the load balancer,
+	// the request is built with the first endpoint and each attempt selects an endpoint.
+	base := r.config.base()
+	balanced := r.config.balancer != nil && isRelativeURI(uri)
+	if balanced {
+		if base, err = r.config.balancer.base(); err != nil {
+			return nil, err
+		}
+	}
+	u, err := internal.ResolveURL(base, uri)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// Add common request headers provided by the client. The values are copied, so that
+	// the request can not modify the configuration snapshot.
+	if len(r.config.headers) > 0 {
+		for key, values := range r.config.headers {
+			req.Header[key] = append([]string(nil), values...)
 		}
 	}
 	// Add request headers provided by the current request.
@@ -431,26 +658,188 @@ func (r *request) send(method string) (*http.Response, error) {
 	if r.bodyType != "" {
 		req.Header.Set("Content-Type", r.bodyType)
 	}
+	for _, cookie := range r.cookies {
+		req.AddCookie(cookie)
+	}
 
-	if len(r.query) > 0 {
-		if req.URL.RawQuery == "" {
-			req.URL.RawQuery = r.query.Encode()
-		} else {
-			// If query parameters are already attached to the target URL,
-			// we need to overwrite the set query parameters to the target URL.
-			qs := req.URL.Query()
-			for key, values := range r.query {
+	// The query parameters are merged in the order of precedence: the common query
+	// parameters of the client, the query parameters of the url, and the query parameters
+	// of the current request.
+	if len(r.config.query) > 0 || len(r.query) > 0 {
+		qs := make(url.Values, len(r.config.query))
+		for key, values := range r.config.query {
+			qs[key] = values
+		}
+		for _, key := range r.noQuery {
+			delete(qs, key)
+		}
+		if req.URL.RawQuery != "" {
+			for key, values := range req.URL.Query() {
 				qs[key] = values
 			}
-			req.URL.RawQuery = qs.Encode()
+		}
+		for key, values := range r.query {
+			qs[key] = values
+		}
+		req.URL.RawQuery = qs.Encode()
+	}
+
+	h := r.handler()
+	if balanced {
+		h = r.config.balancer.middleware(h, uri, r.client.sender(r.config))
+	}
+	// Each retry attempt is hedged.
+	hedge := r.hedgeDelay > 0 && r.hedges > 0 && isIdempotentRequest(req)
+	if hedge {
+		h = hedgeHandler(h, r.hedgeDelay, r.hedges)
+	}
+	policy := r.getRetryPolicy()
+	retry := policy.enabled(req)
+	// Each attempt needs a fresh copy of the request body, and so does the authenticator
+	// that handles the authentication challenge.
+	if _, ok := r.getAuthenticator().(challenger); retry || hedge || ok {
+		if err = makeReplayableBody(req); err != nil {
+			return nil, err
 		}
 	}
+	var o *http.Response
+	if retry {
+		o, err = policy.do(req, h)
+	} else {
+		o, err = h(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The response may be a synthetic response created by a middleware.
+	if o.Body == nil {
+		o.Body = http.NoBody
+	}
+	if o.Request == nil {
+		o.Request = req
+	}
+	// The responder must be executed before the context is cancelled, otherwise the
+	// response body can not be read.
+	return r.fromResponder(o, noBody)
+}
+
+// The handler method returns the handler that sends a single attempt of the request.
+// The client middlewares are the outermost, followed by the request middlewares and the
+// built-in features of the client.
+func (r *request) handler() Handler {
+	h := r.do
+	if signer := r.getSigner(); signer != nil {
+		h = signerMiddleware(signer)(h)
+	}
+	if r.config.breaker != nil {
+		h = r.config.breaker.middleware(h)
+	}
+	// The requests rejected by the bulkhead are not counted by the circuit breaker.
+	if r.config.bulkhead != nil {
+		h = r.config.bulkhead.middleware(h)
+	}
+	if r.config.limiter != nil {
+		h = r.config.limiter.middleware(h)
+	}
+	if auth := r.getAuthenticator(); auth != nil {
+		h = authMiddleware(auth)(h)
+	}
+	return composeMiddlewares(composeMiddlewares(h, r.middlewares), r.config.middlewares)
+}
+
+// The do method sends the given HTTP request by the HTTP client of the client.
+// If the timings are enabled, each attempt captures its own timings.
+func (r *request) do(req *http.Request) (*http.Response, error) {
+	markSent(req)
+	if !r.timings && !r.config.timings {
+		return r.client.httpClient(r.config, req).Do(req)
+	}
+	req, t := traceRequest(req)
+	o, err := r.client.httpClient(r.config, req).Do(req)
+	if err == nil {
+		t.wrap(o)
+	}
+	return o, err
+}
 
-	if r.client.http == nil {
-		return internal.Client.Do(req)
+// The expandPathParams method replaces the placeholders in the request url with the
+// escaped path parameters. The placeholders in the query and fragment of the request url
+// are escaped as query values.
+func (r *request) expandPathParams() (string, error) {
+	var missing string
+	used := make(map[string]bool, len(r.pathParams))
+	expand := func(uri string, escape func(string) string) string {
+		return pathParamPattern.ReplaceAllStringFunc(uri, func(s string) string {
+			name := s[1 : len(s)-1]
+			if value, found := r.pathParams[name]; found {
+				used[name] = true
+				return escape(value)
+			}
+			if missing == "" {
+				missing = name
+			}
+			return s
+		})
+	}
+	uri := r.uri
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		uri = expand(uri[:i], escapePathParam) + expand(uri[i:], url.QueryEscape)
 	} else {
-		return r.client.http.Do(req)
+		uri = expand(uri, escapePathParam)
+	}
+	if missing != "" {
+		return "", fmt.Errorf("%w: %s", ErrMissingPathParam, missing)
+	}
+	if len(used) < len(r.pathParams) {
+		names := make([]string, 0, len(r.pathParams)-len(used))
+		for name := range r.pathParams {
+			if !used[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("%w: %s", ErrUnusedPathParam, strings.Join(names, ", "))
+	}
+	return uri, nil
+}
+
+// The escapePathParam function escapes the given path parameter as a path segment.
+// The dot segments are escaped, so that they can not change the path of the request.
+func escapePathParam(value string) string {
+	switch value {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+	return url.PathEscape(value)
+}
+
+// Gets the request retry policy.
+func (r *request) getRetryPolicy() *RetryPolicy {
+	if r.retry != nil {
+		return r.retry
 	}
+	return r.config.retry
+}
+
+// Gets the request authenticator.
+func (r *request) getAuthenticator() Authenticator {
+	if r.noAuth {
+		return nil
+	}
+	if r.auth != nil {
+		return r.auth
+	}
+	return r.config.auth
+}
+
+// Gets the request signer.
+func (r *request) getSigner() Signer {
+	if r.signer != nil {
+		return r.signer
+	}
+	return r.config.signer
 }
 
 // Gets the request context.
@@ -462,8 +851,8 @@ func (r *request) getContext() (context.Context, context.CancelFunc) {
 	if r.timeout > 0 {
 		return context.WithTimeout(ctx, r.timeout)
 	}
-	if r.client.timeout > 0 {
-		return context.WithTimeout(ctx, r.client.timeout)
+	if r.config.timeout > 0 {
+		return context.WithTimeout(ctx, r.config.timeout)
 	}
 	return ctx, nil
 }
@@ -512,6 +901,32 @@ func (r *request) makeBodyReader() (io.Reader, error) {
 	return nil, ErrInvalidRequestBody
 }
 
+// The makeReplayableBody function makes the body of the given request replayable.
+// The bodies of the known types (bytes.Buffer, bytes.Reader and strings.Reader) are
+// already replayable, other bodies are read into memory.
+func makeReplayableBody(req *http.Request) error {
+	if isReplayableRequest(req) {
+		return nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	internal.ForceClose(req.Body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return nil
+}
+
+// The isReplayableRequest function determines whether the body of the given request can
+// be sent again.
+func isReplayableRequest(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
 // WithFormDataField adds a form data for uploading to the current request.
 // If the given form value is nil, delete the corresponding form key.
 func (r *request) WithFormDataField(key string, value interface{}) Request {
@@ -592,7 +1007,9 @@ func (r *request) Upload() (Response, error) {
 // This method will send the request using the given request method.
 // This method only supports POST method and PUT method.
 func (r *request) UploadBy(method string) (Response, error) {
-	if r.uri == "" {
+	// The configuration of the client is captured once for the whole request.
+	r.config = r.client.load()
+	if r.uri == "" && r.config.base() == "" && r.config.balancer == nil {
 		return nil, ErrEmptyRequestURL
 	}
 
@@ -669,11 +1086,7 @@ func (r *request) UploadBy(method string) (Response, error) {
 	r.bodyEncoder = ""
 	r.bodyType = w.FormDataContentType()
 
-	if o, err := r.send(method); err != nil {
-		return nil, err
-	} else {
-		return r.fromResponder(o, false)
-	}
+	return r.send(method, false)
 }
 
 // Clear cleans up the current request instance so that it can be reused.
@@ -684,11 +1097,24 @@ func (r *request) Clear() Request {
 	r.headers = nil
 	r.ctx = nil
 	r.query = nil
+	r.noQuery = nil
+	r.pathParams = nil
 	r.timeout = 0
 	r.body = nil
 	r.bodyEncoder = ""
 	r.bodyType = ""
 	r.responder = nil
+	r.middlewares = nil
+	r.retry = nil
+	r.hedgeDelay = 0
+	r.hedges = 0
+	r.timings = false
+	r.auth = nil
+	r.noAuth = false
+	r.signer = nil
+	r.cookies = nil
+	r.proxy = nil
+	r.noProxy = false
 
 	return r.ClearFormData()
 }
@@ -697,6 +1123,7 @@ func (r *request) Clear() Request {
 func (r *request) reset() *request {
 	r.Clear()
 	r.client = nil
+	r.config = nil
 	r.uri = ""
 
 	return r
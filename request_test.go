@@ -566,3 +566,96 @@ func TestRequestResponder(t *testing.T) {
 		return nil, nil
 	})
 }
+
+func TestRequestPathParams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.EscapedPath())
+	}))
+	defer server.Close()
+
+	c := New().SetBaseURL(server.URL)
+
+	_, _ = c.Do("/orgs/{org}/repos/{id}", func(r Request) (Response, error) {
+		if r.WithPathParam("org", "a b/c") == nil {
+			t.Fatal("Request.WithPathParam() return nil")
+		}
+		if r.WithPathParams(map[string]interface{}{"id": 10}) == nil {
+			t.Fatal("Request.WithPathParams() return nil")
+		}
+		res, err := r.Get()
+		if err != nil {
+			t.Fatalf("Request.Get() error: %s", err)
+		}
+		if got := res.String(); got != "/orgs/a%20b%2Fc/repos/10" {
+			t.Fatalf("Request.Get() got path %s", got)
+		}
+		return nil, nil
+	})
+
+	// The dot segments can not change the path of the request.
+	c.SetBaseURL(server.URL + "/api")
+	for _, value := range []string{"..", "."} {
+		res, err := c.New("/orgs/{org}/repos").WithPathParam("org", value).Get()
+		if err != nil {
+			t.Fatalf("Request.Get() error: %s", err)
+		}
+		if got, want := res.String(), "/api/orgs/"+strings.Repeat("%2E", len(value))+"/repos"; got != want {
+			t.Fatalf("Request.Get() want path %s got %s", want, got)
+		}
+	}
+	res, err := New().New(server.URL+"/orgs/{org}/repos").WithPathParam("org", "..").Get()
+	if err != nil {
+		t.Fatalf("Request.Get() error: %s", err)
+	}
+	if got := res.String(); got != "/orgs/%2E%2E/repos" {
+		t.Fatalf("Request.Get() got path %s", got)
+	}
+
+	// The placeholders in the query are escaped as query values.
+	query := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, fmt.Sprint(r.URL.Query()))
+	}))
+	defer query.Close()
+	res, err = New().New(query.URL + "/search/{kind}?q={term}").WithPathParams(map[string]interface{}{
+		"kind": "repos", "term": "a b&admin=1",
+	}).Get()
+	if err != nil {
+		t.Fatalf("Request.Get() error: %s", err)
+	}
+	if got := res.String(); got != "map[q:[a b&admin=1]]" {
+		t.Fatalf("Request.Get() got query %s", got)
+	}
+
+	_, err = c.New("/orgs/{org}/repos/{id}").WithPathParam("org", "foo").Get()
+	if !errors.Is(err, ErrMissingPathParam) {
+		t.Fatalf("Request.Get() with missing path param return error: %v", err)
+	}
+
+	_, err = c.New("/orgs/{org}").WithPathParams(map[string]interface{}{"org": "foo", "id": 1}).Get()
+	if !errors.Is(err, ErrUnusedPathParam) {
+		t.Fatalf("Request.Get() with unused path param return error: %v", err)
+	}
+
+	_, err = c.New("/orgs/{org}").WithFormDataField("foo", "foo").Upload()
+	if !errors.Is(err, ErrMissingPathParam) {
+		t.Fatalf("Request.Upload() with missing path param return error: %v", err)
+	}
+}
+
+func TestRequestTimeoutBody(t *testing.T) {
+	body := strings.Repeat("a", 1<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		time.Sleep(10 * time.Millisecond)
+		_, _ = io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	res, err := New().SetTimeout(time.Minute).Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	if res.Len() != len(body) {
+		t.Fatalf("Client.Get() got body length %d", res.Len())
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidResolver is returned when the resolver options are invalid.
+var ErrInvalidResolver = errors.New("invalid resolver")
+
+// IPPreference represents the preference of the IP address families.
+type IPPreference int
+
+// These are the preferences of the IP address families.
+const (
+	// IPAny keeps the order of the addresses returned by the resolver.
+	IPAny IPPreference = iota
+	// PreferIPv4 dials the IPv4 addresses first.
+	PreferIPv4
+	// PreferIPv6 dials the IPv6 addresses first.
+	PreferIPv6
+	// IPv4Only only dials the IPv4 addresses.
+	IPv4Only
+	// IPv6Only only dials the IPv6 addresses.
+	IPv6Only
+)
+
+// ResolverOptions defines the host name resolution of the client.
+type ResolverOptions struct {
+	// Hosts is the static addresses of the hosts, like the curl --resolve option.
+	// The key is the host name, or the host name and port like "example.com:443" which
+	// takes precedence over the host name, and the value is the IP addresses.
+	// The overridden hosts are not resolved by the resolver.
+	Hosts map[string][]string
+
+	// Resolver is the resolver used to look up the hosts, such as a resolver that sends
+	// the queries to a specific DNS server. If it is nil, net.DefaultResolver is used.
+	Resolver *net.Resolver
+
+	// CacheTTL is the period the resolved addresses are cached.
+	// If it is zero, the addresses are not cached.
+	CacheTTL time.Duration
+
+	// NegativeCacheTTL is the period the lookup failures are cached.
+	// If it is zero, the failures are not cached.
+	NegativeCacheTTL time.Duration
+
+	// IPPreference is the preference of the IP address families, IPAny by default.
+	IPPreference IPPreference
+}
+
+// The dnsResolver type resolves the hosts of the dials of the derived transport.
+type dnsResolver struct {
+	options ResolverOptions
+	err     error
+	hosts   map[string][]net.IP
+	lookup  func(context.Context, string) ([]net.IPAddr, error)
+	mutex   sync.Mutex
+	cache   map[string]*dnsEntry
+}
+
+// The dnsEntry type is a cached or pending lookup of a host.
+type dnsEntry struct {
+	done      chan struct{}
+	ips       []net.IP
+	err       error
+	expiry    time.Time
+	cancelled bool
+}
+
+// The newDNSResolver function creates a resolver from the given options.
+// If the options are invalid, the resolver returns the error for every dial.
+func newDNSResolver(options *ResolverOptions) *dnsResolver {
+	r := &dnsResolver{
+		options: *options,
+		hosts:   make(map[string][]net.IP, len(options.Hosts)),
+		cache:   make(map[string]*dnsEntry),
+	}
+	resolver := options.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	r.lookup = resolver.LookupIPAddr
+
+	if options.IPPreference < IPAny || options.IPPreference > IPv6Only {
+		r.err = fmt.Errorf("%w: unsupported ip preference %d", ErrInvalidResolver, options.IPPreference)
+		return r
+	}
+	for host, addresses := range options.Hosts {
+		ips := make([]net.IP, 0, len(addresses))
+		for _, address := range addresses {
+			ip := net.ParseIP(strings.Trim(strings.TrimSpace(address), "[]"))
+			if ip == nil {
+				r.err = fmt.Errorf("%w: invalid address %q of %s", ErrInvalidResolver, address, host)
+				return r
+			}
+			ips = append(ips, ip)
+		}
+		r.hosts[strings.ToLower(host)] = ips
+	}
+	return r
+}
+
+// The dialContext method returns the dial function that dials the resolved addresses of
+// the host by the given dial function, until a dial succeeds (see dialParallel).
+func (r *dnsResolver) dialContext(dial func(context.Context, string, string) (net.Conn, error)) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if r.err != nil {
+			return nil, r.err
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, address)
+		}
+		ips, err := r.resolve(ctx, host, port)
+		if err != nil {
+			return nil, err
+		}
+		return dialParallel(ctx, dial, network, port, ips)
+	}
+}
+
+// The resolverFallbackDelay constant is the delay before the addresses of the other IP
+// family are dialed, like the Happy Eyeballs of the net.Dialer.
+const resolverFallbackDelay = 300 * time.Millisecond
+
+// The resolverMinDialTimeout constant is the minimum timeout of dialing an address when
+// the dial deadline is split across the addresses.
+const resolverMinDialTimeout = 2 * time.Second
+
+// The dialParallel function dials the given addresses by the given dial function like
+// the net.Dialer does: the addresses of the family of the first address are dialed one
+// after another, and the addresses of the other family are dialed after a short delay or
+// after the first family fails. The first established connection wins.
+func dialParallel(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), network, port string, ips []net.IP) (net.Conn, error) {
+	var primaries, fallbacks []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (ips[0].To4() != nil) {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(fallbacks) == 0 {
+		return dialSerial(ctx, dial, network, port, primaries)
+	}
+
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, 2)
+	start := func(ips []net.IP, primary bool) {
+		conn, err := dialSerial(ctx, dial, network, port, ips)
+		results <- dialResult{conn: conn, err: err, primary: primary}
+	}
+	go start(primaries, true)
+	pending, fallback := 1, false
+	startFallback := func() {
+		if !fallback {
+			fallback, pending = true, pending+1
+			go start(fallbacks, false)
+		}
+	}
+
+	timer := time.NewTimer(resolverFallbackDelay)
+	defer timer.Stop()
+	var primaryErr, fallbackErr error
+	for {
+		select {
+		case <-timer.C:
+			startFallback()
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if pending > 0 {
+					// The other dial is cancelled, close its connection if it is established.
+					go func() {
+						if res := <-results; res.conn != nil {
+							_ = res.conn.Close()
+						}
+					}()
+				}
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+				startFallback()
+			} else {
+				fallbackErr = res.err
+			}
+			if pending == 0 {
+				if primaryErr != nil {
+					return nil, primaryErr
+				}
+				return nil, fallbackErr
+			}
+		}
+	}
+}
+
+// The dialSerial function dials the given addresses one after another until a connection
+// is established. The remaining time of the dial is split across the addresses, if the
+// given context has no deadline, DefaultDialTimeout is used.
+func dialSerial(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), network, port string, ips []net.IP) (net.Conn, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultDialTimeout)
+	}
+	var err error
+	for i, ip := range ips {
+		dialCtx, cancel := context.WithDeadline(ctx, partialDeadline(time.Now(), deadline, len(ips)-i))
+		conn, e := dial(dialCtx, network, net.JoinHostPort(ip.String(), port))
+		cancel()
+		if e == nil {
+			return conn, nil
+		}
+		if err = e; ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// The partialDeadline function returns the deadline of dialing one of the given number
+// of remaining addresses before the given deadline.
+func partialDeadline(now, deadline time.Time, addresses int) time.Time {
+	remaining := deadline.Sub(now)
+	if remaining <= 0 || addresses <= 1 {
+		return deadline
+	}
+	timeout := remaining / time.Duration(addresses)
+	if timeout < resolverMinDialTimeout {
+		if remaining < resolverMinDialTimeout {
+			return deadline
+		}
+		timeout = resolverMinDialTimeout
+	}
+	return now.Add(timeout)
+}
+
+// The resolve method returns the addresses of the given host in the dialing order.
+func (r *dnsResolver) resolve(ctx context.Context, host, port string) ([]net.IP, error) {
+	host = strings.ToLower(host)
+	ips, ok := r.hosts[net.JoinHostPort(host, port)]
+	if !ok {
+		if ips, ok = r.hosts[host]; !ok {
+			var err error
+			if ips, err = r.lookupCached(ctx, host); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if ips = r.sort(ips); len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// The lookupCached method looks up the given host by the resolver. The concurrent lookups
+// of the same host are merged, and the results are cached by the TTL options.
+func (r *dnsResolver) lookupCached(ctx context.Context, host string) ([]net.IP, error) {
+	for {
+		r.mutex.Lock()
+		e := r.cache[host]
+		if e != nil {
+			select {
+			case <-e.done:
+				if time.Now().Before(e.expiry) {
+					r.mutex.Unlock()
+					return e.ips, e.err
+				}
+				// The entry is expired, look up the host again.
+				e = nil
+			default:
+			}
+		}
+		if e != nil {
+			r.mutex.Unlock()
+			select {
+			case <-e.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if !e.cancelled {
+				return e.ips, e.err
+			}
+			// The lookup is cancelled by the context of another dial, try again.
+			continue
+		}
+
+		e = &dnsEntry{done: make(chan struct{})}
+		r.cache[host] = e
+		r.mutex.Unlock()
+
+		addresses, err := r.lookup(ctx, host)
+		e.ips, e.err = make([]net.IP, 0, len(addresses)), err
+		for _, address := range addresses {
+			e.ips = append(e.ips, address.IP)
+		}
+
+		// The failure caused by the context of the current dial is not cached.
+		ttl := r.options.CacheTTL
+		if err != nil {
+			ttl = r.options.NegativeCacheTTL
+			if e.cancelled = ctx.Err() != nil; e.cancelled {
+				ttl = 0
+			}
+		}
+		r.mutex.Lock()
+		if ttl > 0 {
+			e.expiry = time.Now().Add(ttl)
+		} else if r.cache[host] == e {
+			delete(r.cache, host)
+		}
+		close(e.done)
+		r.mutex.Unlock()
+		return e.ips, e.err
+	}
+}
+
+// The sort method filters and sorts the given addresses by the IP preference.
+func (r *dnsResolver) sort(ips []net.IP) []net.IP {
+	if r.options.IPPreference == IPAny {
+		return ips
+	}
+	v4 := make([]net.IP, 0, len(ips))
+	v6 := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch r.options.IPPreference {
+	case PreferIPv4:
+		return append(v4, v6...)
+	case PreferIPv6:
+		return append(v6, v4...)
+	case IPv4Only:
+		return v4
+	}
+	return v6
+}
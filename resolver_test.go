@@ -0,0 +1,294 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_SetResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host)
+	}))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	c := New().SetTimeout(5 * time.Second).SetResolver(&ResolverOptions{
+		Hosts: map[string][]string{
+			// The first address refuses the connection, and the second one is dialed.
+			"example.test":       {"127.0.0.2", "127.0.0.1"},
+			"other.test":         {"127.0.0.2"},
+			"other.test:" + port: {"127.0.0.1"},
+			"unsuitable.test":    {"::1"},
+			"Upper.Example.Test": {"127.0.0.1"},
+		},
+		IPPreference: IPv4Only,
+	})
+	for _, host := range []string{"example.test", "other.test", "upper.example.test"} {
+		res, err := c.New("http://" + host + ":" + port + "/").Get()
+		if err != nil {
+			t.Fatalf("Client.SetResolver(): %s", err)
+		}
+		if got := res.String(); got != host+":"+port {
+			t.Fatalf("Client.SetResolver(): %s", got)
+		}
+	}
+	var dnsErr *net.DNSError
+	if _, err := c.New("http://unsuitable.test:" + port + "/").Get(); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("Client.SetResolver(): %v", err)
+	}
+
+	// The IP addresses are not resolved.
+	if _, err := c.New(server.URL).Get(); err != nil {
+		t.Fatalf("Client.SetResolver(): %s", err)
+	}
+	// The invalid options fail all dials.
+	c = New().SetResolver(&ResolverOptions{Hosts: map[string][]string{"example.test": {"invalid"}}})
+	if _, err := c.New(server.URL).Get(); !errors.Is(err, ErrInvalidResolver) {
+		t.Fatalf("Client.SetResolver(): %v", err)
+	}
+	c = New().SetResolver(&ResolverOptions{IPPreference: IPPreference(9)})
+	if _, err := c.New(server.URL).Get(); !errors.Is(err, ErrInvalidResolver) {
+		t.Fatalf("Client.SetResolver(): %v", err)
+	}
+}
+
+func TestClient_SetResolver_Resolver(t *testing.T) {
+	var dials int32
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return nil, errors.New("no dns server")
+		},
+	}
+	c := New().SetResolver(&ResolverOptions{Resolver: resolver, NegativeCacheTTL: time.Hour})
+	if _, err := c.New("http://missing.example.test/").Get(); err == nil {
+		t.Fatal("Client.SetResolver(): nil error")
+	}
+	n := atomic.LoadInt32(&dials)
+	if n == 0 {
+		t.Fatal("Client.SetResolver(): the resolver is not used")
+	}
+	// The failure is cached.
+	if _, err := c.New("http://missing.example.test/").Get(); err == nil {
+		t.Fatal("Client.SetResolver(): nil error")
+	}
+	if got := atomic.LoadInt32(&dials); got != n {
+		t.Fatalf("Client.SetResolver(): dials %d != %d", got, n)
+	}
+}
+
+func TestDNSResolver_Cache(t *testing.T) {
+	var lookups int32
+	r := newDNSResolver(&ResolverOptions{CacheTTL: time.Hour, NegativeCacheTTL: 20 * time.Millisecond})
+	r.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		atomic.AddInt32(&lookups, 1)
+		if strings.HasPrefix(host, "missing") {
+			return nil, &net.DNSError{Err: "not found", Name: host, IsNotFound: true}
+		}
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		ips, err := r.resolve(context.Background(), "example.test", "80")
+		if err != nil {
+			t.Fatalf("dnsResolver.resolve(): %s", err)
+		}
+		if len(ips) != 1 || ips[0].String() != "127.0.0.1" {
+			t.Fatalf("dnsResolver.resolve(): %v", ips)
+		}
+		if _, err = r.resolve(context.Background(), "missing.test", "80"); err == nil {
+			t.Fatal("dnsResolver.resolve(): nil error")
+		}
+	}
+	if got := atomic.LoadInt32(&lookups); got != 2 {
+		t.Fatalf("dnsResolver.resolve(): lookups %d", got)
+	}
+	// The negative cache entry is expired.
+	time.Sleep(30 * time.Millisecond)
+	if _, err := r.resolve(context.Background(), "missing.test", "80"); err == nil {
+		t.Fatal("dnsResolver.resolve(): nil error")
+	}
+	if got := atomic.LoadInt32(&lookups); got != 3 {
+		t.Fatalf("dnsResolver.resolve(): lookups %d", got)
+	}
+
+	// The lookups are not cached without the TTL options.
+	r.options.CacheTTL, r.options.NegativeCacheTTL = 0, 0
+	for i := 0; i < 2; i++ {
+		if _, err := r.resolve(context.Background(), "new.test", "80"); err != nil {
+			t.Fatalf("dnsResolver.resolve(): %s", err)
+		}
+	}
+	if got := atomic.LoadInt32(&lookups); got != 5 {
+		t.Fatalf("dnsResolver.resolve(): lookups %d", got)
+	}
+}
+
+func TestDNSResolver_Concurrent(t *testing.T) {
+	var lookups int32
+	release := make(chan struct{})
+	r := newDNSResolver(&ResolverOptions{})
+	r.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if atomic.AddInt32(&lookups, 1) == 1 {
+			// The first lookup is cancelled by its context.
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		<-release
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := r.resolve(ctx, "example.test", "80")
+		first <- err
+	}()
+	for atomic.LoadInt32(&lookups) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	results := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := r.resolve(context.Background(), "example.test", "80")
+			results <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("dnsResolver.resolve(): %v", err)
+	}
+	for atomic.LoadInt32(&lookups) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("dnsResolver.resolve(): %s", err)
+		}
+	}
+	// The waiting lookups are merged into a single lookup.
+	if got := atomic.LoadInt32(&lookups); got != 2 {
+		t.Fatalf("dnsResolver.resolve(): lookups %d", got)
+	}
+}
+
+func TestDNSResolver_Sort(t *testing.T) {
+	ips := []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1"), net.ParseIP("fe80::1"), net.ParseIP("10.0.0.1")}
+	items := map[IPPreference]string{
+		IPAny:      "::1,127.0.0.1,fe80::1,10.0.0.1",
+		PreferIPv4: "127.0.0.1,10.0.0.1,::1,fe80::1",
+		PreferIPv6: "::1,fe80::1,127.0.0.1,10.0.0.1",
+		IPv4Only:   "127.0.0.1,10.0.0.1",
+		IPv6Only:   "::1,fe80::1",
+	}
+	for preference, want := range items {
+		r := newDNSResolver(&ResolverOptions{IPPreference: preference})
+		var got []string
+		for _, ip := range r.sort(ips) {
+			got = append(got, ip.String())
+		}
+		if s := strings.Join(got, ","); s != want {
+			t.Fatalf("dnsResolver.sort(): %d %s", preference, s)
+		}
+	}
+}
+
+func TestDNSResolver_Dial(t *testing.T) {
+	// The IPv6 addresses are broken, the IPv4 address is dialed after the fallback delay.
+	var dialed []string
+	var mutex sync.Mutex
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		mutex.Lock()
+		dialed = append(dialed, address)
+		mutex.Unlock()
+		if strings.HasPrefix(address, "[") {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		conn, _ := net.Pipe()
+		return conn, nil
+	}
+	r := newDNSResolver(&ResolverOptions{
+		Hosts:        map[string][]string{"example.test": {"::1", "fe80::1", "127.0.0.1"}},
+		IPPreference: PreferIPv6,
+	})
+	start := time.Now()
+	conn, err := r.dialContext(dial)(context.Background(), "tcp", "example.test:80")
+	if err != nil {
+		t.Fatalf("dnsResolver.dialContext(): %s", err)
+	}
+	_ = conn.Close()
+	if d := time.Since(start); d < resolverFallbackDelay || d > resolverMinDialTimeout {
+		t.Fatalf("dnsResolver.dialContext(): %s", d)
+	}
+	mutex.Lock()
+	got := strings.Join(dialed, ",")
+	mutex.Unlock()
+	if got != "[::1]:80,127.0.0.1:80" {
+		t.Fatalf("dnsResolver.dialContext(): %s", got)
+	}
+
+	// The deadline of the dial is split across the addresses.
+	var deadlines []time.Duration
+	dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		deadline, _ := ctx.Deadline()
+		deadlines = append(deadlines, time.Until(deadline))
+		return nil, errors.New("refused")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r = newDNSResolver(&ResolverOptions{Hosts: map[string][]string{"example.test": {"127.0.0.2", "127.0.0.1"}}})
+	if _, err = r.dialContext(dial)(ctx, "tcp", "example.test:80"); err == nil {
+		t.Fatal("dnsResolver.dialContext(): nil error")
+	}
+	if len(deadlines) != 2 || deadlines[0] > 5*time.Second || deadlines[0] < 4*time.Second || deadlines[1] < 9*time.Second {
+		t.Fatalf("dnsResolver.dialContext(): %v", deadlines)
+	}
+}
+
+func TestPartialDeadline(t *testing.T) {
+	now := time.Now()
+	items := []struct {
+		Remaining time.Duration
+		Addresses int
+		Want      time.Duration
+	}{
+		{10 * time.Second, 2, 5 * time.Second},
+		{10 * time.Second, 1, 10 * time.Second},
+		{3 * time.Second, 3, 2 * time.Second},
+		{time.Second, 3, time.Second},
+		{-time.Second, 3, -time.Second},
+	}
+	for _, item := range items {
+		if got := partialDeadline(now, now.Add(item.Remaining), item.Addresses).Sub(now); got != item.Want {
+			t.Fatalf("partialDeadline(): %s %d want %s got %s", item.Remaining, item.Addresses, item.Want, got)
+		}
+	}
+}
@@ -49,6 +49,17 @@ type Response interface {
 
 	// XML binds the response body to the given object as xml.
 	XML(interface{}) error
+
+	// Cookies parses and returns the cookies set in the "Set-Cookie" response headers.
+	Cookies() []*http.Cookie
+
+	// HedgedAttempt returns the number of the hedged attempt that produced the response,
+	// 0 is the original attempt and n is the n-th hedge (see Request.WithHedging).
+	HedgedAttempt() int
+
+	// Timings returns the timings of the request that produced the response, or nil if
+	// the timings are not captured (see Request.WithTimings and Client.SetTimings).
+	Timings() *Timings
 }
 
 // Responder defines the Response instance factory.
@@ -63,22 +74,26 @@ type Responder func(r *http.Response, noBody bool) (Response, error)
 // NewResponse returns a built-in implementation of the Response interface
 // from a given http.Response instance.
 // If noBody is true, the response body is discarded.
+// The timings of the request are taken after the response body is read (see ResponseTimings).
 func NewResponse(o *http.Response, noBody bool) (Response, error) {
 	defer func() { _ = o.Body.Close() }()
 	res := &response{
 		code:    o.StatusCode,
 		status:  o.Status,
 		headers: o.Header.Clone(),
+		attempt: HedgedAttempt(o),
 	}
 	if noBody {
 		// To ensure that TCP connections can be reused, we discard the response body.
 		_, _ = io.Copy(ioutil.Discard, o.Body)
+		res.timings = ResponseTimings(o)
 		return res, nil
 	}
 	if body, err := ioutil.ReadAll(o.Body); err != nil {
 		return nil, err
 	} else {
 		res.body = body
+		res.timings = ResponseTimings(o)
 		return res, nil
 	}
 }
@@ -111,6 +126,8 @@ type response struct {
 	status  string
 	headers http.Header
 	body    []byte
+	attempt int
+	timings *Timings
 }
 
 // Headers method returns all response headers.
@@ -148,6 +165,21 @@ func (r *response) XML(o interface{}) error {
 	return xml.Unmarshal(r.Body(), o)
 }
 
+// Cookies parses and returns the cookies set in the "Set-Cookie" response headers.
+func (r *response) Cookies() []*http.Cookie {
+	return (&http.Response{Header: r.headers}).Cookies()
+}
+
+// HedgedAttempt returns the number of the hedged attempt that produced the response.
+func (r *response) HedgedAttempt() int {
+	return r.attempt
+}
+
+// Timings returns the timings of the request that produced the response.
+func (r *response) Timings() *Timings {
+	return r.timings
+}
+
 // String returns the response body string, or empty string if there is no response body.
 func (r *response) String() string {
 	return string(r.body)
@@ -208,6 +240,24 @@ func (*emptyResponse) XML(o interface{}) error {
 	return errors.New("requester: empty response")
 }
 
+// Cookies implements the Response interface.
+// The method always return nil.
+func (*emptyResponse) Cookies() []*http.Cookie {
+	return nil
+}
+
+// HedgedAttempt implements the Response interface.
+// The method always return 0.
+func (*emptyResponse) HedgedAttempt() int {
+	return 0
+}
+
+// Timings implements the Response interface.
+// The method always return nil.
+func (*emptyResponse) Timings() *Timings {
+	return nil
+}
+
 // String implements the Response interface.
 // The method always return empty string.
 func (*emptyResponse) String() string {
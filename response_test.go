@@ -171,6 +171,9 @@ func TestEmptyResponse(t *testing.T) {
 	if got := r.Len(); got != 0 {
 		t.Fatalf("NewEmptyResponse().Len(): %d", got)
 	}
+	if got := r.Cookies(); got != nil {
+		t.Fatalf("NewEmptyResponse().Cookies(): %v", got)
+	}
 
 	var obj struct{}
 	if r.JSON(&obj) == nil {
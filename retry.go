@@ -0,0 +1,253 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default values of the RetryPolicy fields.
+const (
+	DefaultRetryMinBackoff = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 10 * time.Second
+	DefaultRetryMultiplier = 2.0
+)
+
+// RetryPolicy defines how failed requests are retried.
+// The zero value does not retry, at least MaxAttempts must be set to enable retrying.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// Values less than 2 disable retrying.
+	MaxAttempts int
+
+	// MinBackoff is the waiting time before the first retry.
+	// If it is zero, DefaultRetryMinBackoff is used.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the upper limit of the waiting time between attempts.
+	// If the "Retry-After" response header asks for a longer waiting time, the request is
+	// not retried and the response is returned.
+	// If it is zero, DefaultRetryMaxBackoff is used.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the waiting time grows after each attempt.
+	// If it is less than 1, DefaultRetryMultiplier is used.
+	Multiplier float64
+
+	// Jitter is the randomization factor of the waiting time, in the range [0, 1].
+	// The actual waiting time is randomly chosen in [backoff*(1-Jitter), backoff].
+	Jitter float64
+
+	// RetryIf reports whether the result of an attempt should be retried.
+	// If it is nil, DefaultRetryIf is used.
+	RetryIf func(*http.Response, error) bool
+
+	// RetryNonIdempotent allows retrying non-idempotent requests (POST, PATCH ...).
+	// By default, only idempotent requests are retried, that is, requests using the
+	// GET, HEAD, OPTIONS, TRACE, PUT and DELETE methods, or carrying an "Idempotency-Key"
+	// or "X-Idempotency-Key" header.
+	RetryNonIdempotent bool
+
+	// IgnoreRetryAfter disables honoring the "Retry-After" response header.
+	IgnoreRetryAfter bool
+}
+
+// The nonRetryableErrors contains the errors that are not retried by DefaultRetryIf.
+var nonRetryableErrors = []error{
+	context.Canceled, context.DeadlineExceeded, ErrCircuitOpen, ErrRateLimited, ErrBulkheadFull,
+}
+
+// DefaultRetryIf is the default retry predicate of the RetryPolicy.
+// It retries transport errors (except context cancellation and deadline, and errors of
+// the built-in client features such as ErrCircuitOpen and ErrRateLimited) and responses
+// with status 429, 502, 503 and 504.
+func DefaultRetryIf(o *http.Response, err error) bool {
+	if err != nil {
+		for _, target := range nonRetryableErrors {
+			if errors.Is(err, target) {
+				return false
+			}
+		}
+		return true
+	}
+	switch o.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// The enabled method determines whether the given request can be retried by the current policy.
+func (p *RetryPolicy) enabled(req *http.Request) bool {
+	if p == nil || p.MaxAttempts < 2 {
+		return false
+	}
+	return p.RetryNonIdempotent || isIdempotentRequest(req)
+}
+
+// The shouldRetry method determines whether the given attempt result should be retried.
+func (p *RetryPolicy) shouldRetry(o *http.Response, err error) bool {
+	if p.RetryIf != nil {
+		return p.RetryIf(o, err)
+	}
+	return DefaultRetryIf(o, err)
+}
+
+// The backoff method returns the waiting time after the given attempt (starting at 1).
+// If the "Retry-After" header of the given response exceeds the maximum waiting time,
+// ok is false and the request should not be retried.
+func (p *RetryPolicy) backoff(attempt int, o *http.Response) (wait time.Duration, ok bool) {
+	min, max, multiplier := p.MinBackoff, p.MaxBackoff, p.Multiplier
+	if max <= 0 {
+		max = DefaultRetryMaxBackoff
+	}
+	if o != nil && !p.IgnoreRetryAfter {
+		if d, found := parseRetryAfter(o.Header.Get("Retry-After"), time.Now()); found {
+			return d, d <= max
+		}
+	}
+
+	if min <= 0 {
+		min = DefaultRetryMinBackoff
+	}
+	if multiplier < 1 {
+		multiplier = DefaultRetryMultiplier
+	}
+
+	d := float64(min) * math.Pow(multiplier, float64(attempt-1))
+	if d > float64(max) {
+		d = float64(max)
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= d * jitter * rand.Float64()
+	}
+	return time.Duration(d), true
+}
+
+// The do method sends the given request with the given handler, and retries it according
+// to the current policy until it succeeds, the attempts are exhausted, or the request
+// context is done. Each attempt goes through all the middlewares.
+// The body of the given request must be replayable (GetBody is set) if it has a body.
+func (p *RetryPolicy) do(req *http.Request, h Handler) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		o, err := sendAttempt(req, h)
+		if attempt >= p.MaxAttempts || ctx.Err() != nil || !p.shouldRetry(o, err) {
+			return o, err
+		}
+
+		wait, ok := p.backoff(attempt, o)
+		if !ok {
+			// The server asks for a longer waiting time than the policy allows.
+			return o, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			// There is not enough time left for the next attempt, the current result is
+			// more useful to the caller than a deadline error.
+			return o, err
+		}
+		if o != nil {
+			drainResponseBody(o)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// The sendAttempt function sends a copy of the given request with the given handler,
+// so that each attempt has its own headers and body.
+func sendAttempt(req *http.Request, h Handler) (*http.Response, error) {
+	c := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		c.Body = body
+	}
+	return h(c)
+}
+
+// The drainResponseBody function discards a small amount of the given response body and
+// closes it, so that the underlying connection can be reused.
+func drainResponseBody(o *http.Response) {
+	if o.Body != nil {
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(o.Body, 4<<10))
+		_ = o.Body.Close()
+	}
+}
+
+// The isIdempotentRequest function determines whether the given request is idempotent.
+func isIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	// The same convention as the http.Transport.
+	if _, found := req.Header["Idempotency-Key"]; found {
+		return true
+	}
+	if _, found := req.Header["X-Idempotency-Key"]; found {
+		return true
+	}
+	return false
+}
+
+// The parseRetryAfter function parses the value of the "Retry-After" header, which is
+// either a number of seconds or an HTTP-date.
+func parseRetryAfter(s string, now time.Time) (time.Duration, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n < 0 {
+			return 0, false
+		}
+		if n > math.MaxInt64/int64(time.Second) {
+			// The waiting time overflows, it exceeds any maximum waiting time.
+			return math.MaxInt64, true
+		}
+		return time.Duration(n) * time.Second, true
+	} else if errors.Is(err, strconv.ErrRange) && s[0] != '-' {
+		return math.MaxInt64, true
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
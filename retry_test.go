@@ -0,0 +1,211 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	c := New()
+	if c.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}) == nil {
+		t.Fatal("Client.SetRetryPolicy() return nil")
+	}
+
+	res, err := c.New(server.URL).WithBody(strings.NewReader("put")).SendBy(http.MethodPut)
+	if err != nil {
+		t.Fatalf("Request.SendBy() error: %s", err)
+	}
+	if res.StatusCode() != http.StatusOK || res.String() != "put" {
+		t.Fatalf("Request.SendBy() got %d %q", res.StatusCode(), res.String())
+	}
+	if n := atomic.LoadInt32(&count); n != 3 {
+		t.Fatalf("Request.SendBy() sent %d attempts", n)
+	}
+
+	// POST requests are not retried by default.
+	atomic.StoreInt32(&count, 0)
+	if res, err := c.Post(server.URL, "post"); err != nil {
+		t.Fatalf("Client.Post() error: %s", err)
+	} else {
+		if res.StatusCode() != http.StatusServiceUnavailable {
+			t.Fatalf("Client.Post() got %d", res.StatusCode())
+		}
+	}
+
+	// The request retry policy overrides the client retry policy.
+	atomic.StoreInt32(&count, 0)
+	req := c.New(server.URL).WithBody(io.MultiReader(strings.NewReader("po"), strings.NewReader("st")))
+	if req.WithRetry(&RetryPolicy{MaxAttempts: 5, MinBackoff: time.Millisecond, RetryNonIdempotent: true}) == nil {
+		t.Fatal("Request.WithRetry() return nil")
+	}
+	if res, err := req.Post(); err != nil {
+		t.Fatalf("Request.Post() error: %s", err)
+	} else {
+		if res.StatusCode() != http.StatusOK || res.String() != "post" {
+			t.Fatalf("Request.Post() got %d %q", res.StatusCode(), res.String())
+		}
+	}
+
+	atomic.StoreInt32(&count, 0)
+	if res, err := c.New(server.URL).WithRetry(&RetryPolicy{}).Get(); err != nil {
+		t.Fatalf("Request.Get() error: %s", err)
+	} else {
+		if res.StatusCode() != http.StatusServiceUnavailable {
+			t.Fatalf("Request.Get() got %d", res.StatusCode())
+		}
+	}
+}
+
+func TestRetryPolicy_Deadline(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	c := New().SetTimeout(time.Second).SetRetryPolicy(&RetryPolicy{MaxAttempts: 3})
+	res, err := c.Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	if res.StatusCode() != http.StatusTooManyRequests {
+		t.Fatalf("Client.Get() got %d", res.StatusCode())
+	}
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Fatalf("Client.Get() sent %d attempts", n)
+	}
+}
+
+func TestRetryPolicy_RetryAfter(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("Retry-After", "86400")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Without a deadline, the Retry-After header exceeding the MaxBackoff is not waited.
+	c := New().SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, MaxBackoff: time.Second})
+	res, err := c.Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	}
+	if res.StatusCode() != http.StatusServiceUnavailable {
+		t.Fatalf("Client.Get() got %d", res.StatusCode())
+	}
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Fatalf("Client.Get() sent %d attempts", n)
+	}
+}
+
+func TestRetryPolicy_Error(t *testing.T) {
+	var count int32
+	c := New().SetRetryPolicy(&RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond})
+	c.Use(func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&count, 1)
+			return nil, errors.New("connection reset")
+		}
+	})
+	if _, err := c.Get("http://127.0.0.1", nil); err == nil {
+		t.Fatal("Client.Get() return nil error")
+	}
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Fatalf("Client.Get() sent %d attempts", n)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		if got, _ := p.backoff(attempt, nil); got != want {
+			t.Fatalf("RetryPolicy.backoff(%d) want %s got %s", attempt, want, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		if got, _ := p.backoff(2, nil); got < time.Second || got > 2*time.Second {
+			t.Fatalf("RetryPolicy.backoff() with jitter got %s", got)
+		}
+	}
+
+	o := &http.Response{Header: http.Header{"Retry-After": {"4"}}}
+	if got, ok := p.backoff(1, o); got != 4*time.Second || !ok {
+		t.Fatalf("RetryPolicy.backoff() with Retry-After got %s %v", got, ok)
+	}
+	// The Retry-After header exceeding the MaxBackoff stops retrying.
+	o.Header.Set("Retry-After", "7")
+	if got, ok := p.backoff(1, o); ok {
+		t.Fatalf("RetryPolicy.backoff() with long Retry-After got %s %v", got, ok)
+	}
+	o.Header.Set("Retry-After", "9300000000")
+	if got, ok := p.backoff(1, o); ok {
+		t.Fatalf("RetryPolicy.backoff() with overflowing Retry-After got %s %v", got, ok)
+	}
+	p.IgnoreRetryAfter = true
+	if got, ok := p.backoff(1, o); got > time.Second || !ok {
+		t.Fatalf("RetryPolicy.backoff() ignore Retry-After got %s %v", got, ok)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := []struct {
+		Give string
+		Want time.Duration
+		OK   bool
+	}{
+		{"", 0, false},
+		{"10", 10 * time.Second, true},
+		{"-1", 0, false},
+		{"Fri, 01 Jan 2021 00:00:30 GMT", 30 * time.Second, true},
+		{"Thu, 31 Dec 2020 00:00:00 GMT", 0, true},
+		{"foo", 0, false},
+		{"9300000000", math.MaxInt64, true},
+		{"99999999999999999999", math.MaxInt64, true},
+	}
+	for i, item := range items {
+		got, ok := parseRetryAfter(item.Give, now)
+		if got != item.Want || ok != item.OK {
+			t.Fatalf("parseRetryAfter() [%d] want %s %v got %s %v", i, item.Want, item.OK, got, ok)
+		}
+	}
+}
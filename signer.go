@@ -0,0 +1,252 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edoger/zkits-requester/internal"
+)
+
+// Default values of the HMACSignerOptions fields.
+const (
+	DefaultSignatureHeader = "X-Signature"
+	DefaultTimestampHeader = "X-Timestamp"
+	DefaultTimestampFormat = "20060102T150405Z"
+)
+
+// HMACSignatureAlgorithm is the algorithm name of the built-in HMAC signer.
+const HMACSignatureAlgorithm = "HMAC-SHA256"
+
+// Signer interface defines the request signer.
+// The signer is invoked with each attempt of the request, after the request is fully built
+// and authenticated, right before it is sent.
+type Signer interface {
+	// Sign signs the given HTTP request.
+	// The given body is the complete request body, or nil if there is no request body,
+	// the request body itself is not consumed.
+	Sign(req *http.Request, body []byte) error
+}
+
+// SignerFunc type is an adapter to allow the use of ordinary functions as signers.
+type SignerFunc func(*http.Request, []byte) error
+
+// Sign signs the given HTTP request.
+func (f SignerFunc) Sign(req *http.Request, body []byte) error {
+	return f(req, body)
+}
+
+// HMACSignerOptions defines the built-in HMAC-SHA256 canonical request signer.
+type HMACSignerOptions struct {
+	// KeyID is the identifier of the signing key, it is sent in the signature header.
+	KeyID string
+
+	// Secret is the signing key.
+	Secret []byte
+
+	// SignedHeaders is the names of the request headers included in the signature.
+	// The "host" name refers to the request host. The timestamp header is always signed.
+	SignedHeaders []string
+
+	// SignatureHeader is the name of the header that carries the signature.
+	// If it is empty, DefaultSignatureHeader is used.
+	SignatureHeader string
+
+	// TimestampHeader is the name of the header that carries the signing time.
+	// If it is empty, DefaultTimestampHeader is used.
+	TimestampHeader string
+
+	// TimestampFormat is the layout of the signing time.
+	// If it is empty, DefaultTimestampFormat is used.
+	TimestampFormat string
+
+	// Now returns the signing time. If it is nil, time.Now is used.
+	Now func() time.Time
+}
+
+// NewHMACSigner returns a signer that signs the canonical form of the request with HMAC-SHA256.
+//
+// The canonical request (see CanonicalRequest) is hashed with SHA-256, the string to sign is:
+//
+//	HMAC-SHA256 + "\n" + timestamp + "\n" + hex(sha256(canonical request))
+//
+// and the signature header value is:
+//
+//	HMAC-SHA256 KeyId=<key id>, SignedHeaders=<a;b;c>, Signature=<hex signature>
+func NewHMACSigner(options *HMACSignerOptions) Signer {
+	s := &hmacSigner{options: *options}
+	if s.options.SignatureHeader == "" {
+		s.options.SignatureHeader = DefaultSignatureHeader
+	}
+	if s.options.TimestampHeader == "" {
+		s.options.TimestampHeader = DefaultTimestampHeader
+	}
+	if s.options.TimestampFormat == "" {
+		s.options.TimestampFormat = DefaultTimestampFormat
+	}
+	if s.options.Now == nil {
+		s.options.Now = time.Now
+	}
+	s.headers = append(s.headers, strings.ToLower(s.options.TimestampHeader))
+	for _, name := range s.options.SignedHeaders {
+		s.headers = append(s.headers, strings.ToLower(name))
+	}
+	return s
+}
+
+// The hmacSigner type is a built-in implementation of the HMAC-SHA256 canonical request signer.
+type hmacSigner struct {
+	options HMACSignerOptions
+	headers []string
+}
+
+// Sign signs the given HTTP request.
+func (s *hmacSigner) Sign(req *http.Request, body []byte) error {
+	timestamp := s.options.Now().UTC().Format(s.options.TimestampFormat)
+	req.Header.Set(s.options.TimestampHeader, timestamp)
+
+	canonical, signed := canonicalRequest(req, body, s.headers)
+	sum := sha256.Sum256([]byte(canonical))
+
+	mac := hmac.New(sha256.New, s.options.Secret)
+	mac.Write([]byte(HMACSignatureAlgorithm + "\n" + timestamp + "\n" + hex.EncodeToString(sum[:])))
+
+	req.Header.Set(s.options.SignatureHeader, HMACSignatureAlgorithm+" KeyId="+s.options.KeyID+
+		", SignedHeaders="+signed+", Signature="+hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// String returns the redacted description of the signer.
+func (s *hmacSigner) String() string {
+	return HMACSignatureAlgorithm + " " + s.options.KeyID + ":" + RedactedValue
+}
+
+// CanonicalRequest returns the canonical form of the given HTTP request, which is used by
+// the built-in HMAC signer. The canonical form consists of the following lines:
+//
+//	method
+//	escaped path
+//	query parameters sorted by name and value, percent-encoded and joined by "&"
+//	signed headers as "name:value" lines, sorted by lowercase name
+//	(empty line)
+//	lowercase signed header names joined by ";"
+//	hex(sha256(body))
+//
+// Header values are trimmed and multiple values are joined by ",".
+func CanonicalRequest(req *http.Request, body []byte, signedHeaders []string) string {
+	canonical, _ := canonicalRequest(req, body, signedHeaders)
+	return canonical
+}
+
+// The canonicalRequest function returns the canonical form of the given HTTP request and
+// the signed header names.
+func canonicalRequest(req *http.Request, body []byte, signedHeaders []string) (string, string) {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	qs := req.URL.Query()
+	pairs := make([]string, 0, len(qs))
+	for key, values := range qs {
+		for _, value := range values {
+			pairs = append(pairs, escapeCanonical(key)+"="+escapeCanonical(value))
+		}
+	}
+	sort.Strings(pairs)
+
+	names := make([]string, 0, len(signedHeaders))
+	seen := make(map[string]bool, len(signedHeaders))
+	for _, name := range signedHeaders {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	headers := make([]string, len(names))
+	for i, name := range names {
+		var values []string
+		if name == "host" {
+			values = []string{req.Host}
+			if req.Host == "" {
+				values[0] = req.URL.Host
+			}
+		} else {
+			values = append(values, req.Header.Values(name)...)
+		}
+		for j := range values {
+			values[j] = strings.Join(strings.Fields(values[j]), " ")
+		}
+		headers[i] = name + ":" + strings.Join(values, ",")
+	}
+
+	sum := sha256.Sum256(body)
+	signed := strings.Join(names, ";")
+	lines := []string{req.Method, path, strings.Join(pairs, "&")}
+	lines = append(lines, headers...)
+	lines = append(lines, "", signed, hex.EncodeToString(sum[:]))
+	return strings.Join(lines, "\n"), signed
+}
+
+// The escapeCanonical function percent-encodes the given query component as RFC 3986.
+func escapeCanonical(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// The signerMiddleware function returns the middleware that signs the request with the
+// given signer.
+func signerMiddleware(s Signer) Middleware {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			body, err := readRequestBody(req)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.Sign(req, body); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+}
+
+// The readRequestBody function reads the complete body of the given request without
+// consuming it, the body is made replayable if it is not.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		if err := makeReplayableBody(req); err != nil {
+			return nil, err
+		}
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer internal.ForceClose(rc)
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACSigner(t *testing.T) {
+	secret := []byte("secret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp := r.Header.Get("X-Timestamp")
+		canonical := CanonicalRequest(r, body, []string{"host", "x-timestamp", "content-type"})
+		sum := sha256.Sum256([]byte(canonical))
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte("HMAC-SHA256\n" + timestamp + "\n" + hex.EncodeToString(sum[:])))
+		want := "HMAC-SHA256 KeyId=key, SignedHeaders=content-type;host;x-timestamp, Signature=" +
+			hex.EncodeToString(mac.Sum(nil))
+		if got := r.Header.Get("X-Signature"); got != want {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, got)
+			return
+		}
+		_, _ = io.WriteString(w, timestamp+"|"+string(body))
+	}))
+	defer server.Close()
+
+	signer := NewHMACSigner(&HMACSignerOptions{
+		KeyID:         "key",
+		Secret:        secret,
+		SignedHeaders: []string{"Host", "Content-Type"},
+		Now:           func() time.Time { return time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC) },
+	})
+	if s := signer.(interface{ String() string }).String(); strings.Contains(s, "secret") {
+		t.Fatalf("HMACSigner string: %s", s)
+	}
+
+	c := New()
+	if c.SetSigner(signer) == nil {
+		t.Fatal("Client.SetSigner() return nil")
+	}
+	res, err := c.New(server.URL+"/a%20b/c").
+		WithQuery("z", "1 2").
+		WithQuery("a", "~").
+		WithBody(io.MultiReader(strings.NewReader("hello "), strings.NewReader("world"))).
+		WithContentType("text/plain").
+		Post()
+	if err != nil {
+		t.Fatalf("Request.Post() error: %s", err)
+	}
+	if got := res.String(); res.StatusCode() != http.StatusOK || got != "20210102T030405Z|hello world" {
+		t.Fatalf("Request.Post() got %d %q", res.StatusCode(), got)
+	}
+
+	if res, err := c.Get(server.URL, nil); err != nil {
+		t.Fatalf("Client.Get() error: %s", err)
+	} else {
+		if res.StatusCode() != http.StatusOK {
+			t.Fatalf("Client.Get() got %d %q", res.StatusCode(), res.String())
+		}
+	}
+
+	req := c.New(server.URL).WithSigner(SignerFunc(func(req *http.Request, body []byte) error {
+		return errors.New("sign error")
+	}))
+	if req == nil {
+		t.Fatal("Request.WithSigner() return nil")
+	}
+	if _, err := req.Get(); err == nil || err.Error() != "sign error" {
+		t.Fatalf("Request.Get() return error: %v", err)
+	}
+}
+
+func TestCanonicalRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://test.com?b=2&a=2&a=1&c=x+y", nil)
+	req.Header.Add("X-Multi", " a  b ")
+	req.Header.Add("X-Multi", "c")
+
+	want := "GET\n/\na=1&a=2&b=2&c=x%20y\nhost:test.com\nx-multi:a b,c\n\nhost;x-multi\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if got := CanonicalRequest(req, nil, []string{"X-Multi", "host", "x-multi"}); got != want {
+		t.Fatalf("CanonicalRequest() got %q", got)
+	}
+	if got := req.Header.Values("X-Multi"); got[0] != " a  b " {
+		t.Fatalf("CanonicalRequest() modified the request headers: %q", got)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// The unixSocketSchemes maps the schemes of the Unix domain socket base URLs to the
+// schemes of the requests.
+var unixSocketSchemes = map[string]string{"unix": "http", "http+unix": "http", "https+unix": "https"}
+
+// The parseUnixSocketURL function parses the given Unix domain socket base URL, and
+// returns the socket path and the base URL of the requests. The socket path is either
+// the whole part after the scheme, such as "unix:///var/run/docker.sock", or the
+// percent-encoded host, such as "http+unix://%2Fvar%2Frun%2Fdocker.sock/v1.41" whose
+// path "/v1.41" is the base path of the requests. If the given base URL is not a Unix
+// domain socket URL, ok is false.
+func parseUnixSocketURL(base string) (socket string, requestBase string, ok bool) {
+	i := strings.Index(base, "://")
+	if i < 0 {
+		return "", "", false
+	}
+	scheme, found := unixSocketSchemes[strings.ToLower(base[:i])]
+	if !found || base[i+3:] == "" {
+		return "", "", false
+	}
+	socket, path := base[i+3:], ""
+	if !strings.HasPrefix(socket, "/") {
+		if j := strings.Index(socket, "/"); j >= 0 {
+			socket, path = socket[:j], socket[j:]
+		}
+		if strings.Contains(socket, "%") {
+			host, err := url.PathUnescape(socket)
+			if err != nil || host == "" {
+				return "", "", false
+			}
+			return host, scheme + "://" + localHost + path, true
+		}
+		// The relative socket path without the percent-encoded host form.
+		socket = base[i+3:]
+	}
+	return socket, scheme + "://" + localHost, true
+}
+
+// The socket method returns the path of the Unix domain socket that the requests are
+// sent through, or an empty string if the requests are sent over the network.
+// The socket of Client.SetUnixSocket takes precedence over the socket of the base URL.
+func (config *clientConfig) socket() string {
+	if config.unixSocket != "" {
+		return config.unixSocket
+	}
+	socket, _, _ := parseUnixSocketURL(config.baseURL)
+	return socket
+}
+
+// The unixSocketDialContext function returns the dial function that dials the given Unix
+// domain socket by the given dial function for all addresses.
+func unixSocketDialContext(dial func(context.Context, string, string) (net.Conn, error), socket string) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dial(ctx, "unix", socket)
+	}
+}
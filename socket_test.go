@@ -0,0 +1,151 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// The newTestUnixServer function creates a test server that listens on a Unix domain
+// socket and responds with the host and uri of the requests.
+func newTestUnixServer(t *testing.T, dir string) (*httptest.Server, string) {
+	socket := filepath.Join(dir, "api.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("net.Listen(): %s", err)
+	}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Header", r.Header.Get("X-Header"))
+		_, _ = io.WriteString(w, r.Host+" "+r.URL.RequestURI())
+	}))
+	s.Listener = l
+	s.Start()
+	return s, socket
+}
+
+func TestClient_SetUnixSocket(t *testing.T) {
+	dir, clean := newTestTempDir(t)
+	defer clean()
+
+	server, socket := newTestUnixServer(t, dir)
+	defer server.Close()
+
+	c := New().SetUnixSocket(socket).SetCommonHeader("X-Header", "value")
+	res, err := c.New("/v1/containers").WithQuery("all", "1").Get()
+	if err != nil {
+		t.Fatalf("Client.SetUnixSocket(): %s", err)
+	}
+	if got := res.String(); got != "localhost /v1/containers?all=1" {
+		t.Fatalf("Client.SetUnixSocket(): %s", got)
+	}
+	if got := res.Headers().Get("X-Header"); got != "value" {
+		t.Fatalf("Client.SetUnixSocket(): header %q", got)
+	}
+
+	// The host of the base URL is used as the Host header.
+	c.SetBaseURL("http://docker/v1.41")
+	if res, err = c.New("/info").Get(); err != nil {
+		t.Fatalf("Client.SetUnixSocket(): %s", err)
+	}
+	if got := res.String(); got != "docker /v1.41/info" {
+		t.Fatalf("Client.SetUnixSocket(): %s", got)
+	}
+
+	// The requests are sent over the network again.
+	c.SetUnixSocket("").SetBaseURL("")
+	if _, err = c.New("/info").Get(); err == nil {
+		t.Fatal("Client.SetUnixSocket(): nil error")
+	}
+}
+
+func TestClient_SetBaseURL_UnixSocket(t *testing.T) {
+	dir, clean := newTestTempDir(t)
+	defer clean()
+
+	server, socket := newTestUnixServer(t, dir)
+	defer server.Close()
+
+	for _, scheme := range []string{"unix", "http+unix", "HTTP+UNIX"} {
+		c := New().SetBaseURL(scheme + "://" + socket)
+		for _, uri := range []string{"/v1/containers", "v1/containers"} {
+			res, err := c.New(uri).Get()
+			if err != nil {
+				t.Fatalf("Client.SetBaseURL(): %s", err)
+			}
+			if got := res.String(); got != "localhost /v1/containers" {
+				t.Fatalf("Client.SetBaseURL(): %s", got)
+			}
+		}
+		if got := c.GetBaseURL(); got != scheme+"://"+socket {
+			t.Fatalf("Client.GetBaseURL(): %s", got)
+		}
+	}
+}
+
+func TestClient_SetBaseURL_UnixSocketHost(t *testing.T) {
+	dir, clean := newTestTempDir(t)
+	defer clean()
+
+	server, socket := newTestUnixServer(t, dir)
+	defer server.Close()
+
+	// The proxy of the transport is ignored for the Unix domain socket.
+	proxy := func(*http.Request) (*url.URL, error) { return nil, errors.New("proxy") }
+	c := New().SetHTTPClient(&http.Client{Transport: &http.Transport{Proxy: proxy}}).
+		SetBaseURL("http+unix://" + url.PathEscape(socket) + "/v1")
+	for _, uri := range []string{"/containers", "containers"} {
+		res, err := c.New(uri).Get()
+		if err != nil {
+			t.Fatalf("Client.SetBaseURL(): %s", err)
+		}
+		if got := res.String(); got != "localhost /v1/containers" {
+			t.Fatalf("Client.SetBaseURL(): %s", got)
+		}
+	}
+}
+
+func TestParseUnixSocketURL(t *testing.T) {
+	items := []struct {
+		Given  string
+		Socket string
+		Base   string
+		OK     bool
+	}{
+		{"unix:///var/run/docker.sock", "/var/run/docker.sock", "http://localhost", true},
+		{"http+unix:///tmp/api.sock", "/tmp/api.sock", "http://localhost", true},
+		{"https+unix://api.sock", "api.sock", "https://localhost", true},
+		{"http+unix://%2Fvar%2Frun%2Fdocker.sock", "/var/run/docker.sock", "http://localhost", true},
+		{"http+unix://%2Fvar%2Frun%2Fdocker.sock/v1.41", "/var/run/docker.sock", "http://localhost/v1.41", true},
+		{"https+unix://%2ftmp%2fapi.sock/api/", "/tmp/api.sock", "https://localhost/api/", true},
+		{"unix://run/api.sock", "run/api.sock", "http://localhost", true},
+		{"unix://%zz/v1", "", "", false},
+		{"unix://", "", "", false},
+		{"http://localhost", "", "", false},
+		{"/var/run/docker.sock", "", "", false},
+	}
+	for _, item := range items {
+		socket, base, ok := parseUnixSocketURL(item.Given)
+		if socket != item.Socket || base != item.Base || ok != item.OK {
+			t.Fatalf("parseUnixSocketURL(): %s: %s %s %v", item.Given, socket, base, ok)
+		}
+	}
+}
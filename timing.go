@@ -0,0 +1,229 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings represents the timings of a request captured by the net/http/httptrace package.
+// The phases that do not happen (such as the DNS lookup of a reused connection) are zero.
+type Timings struct {
+	// DNS is the duration of the DNS lookup.
+	DNS time.Duration
+
+	// Connect is the duration of establishing the TCP connection.
+	Connect time.Duration
+
+	// TLSHandshake is the duration of the TLS handshake.
+	TLSHandshake time.Duration
+
+	// ServerProcessing is the duration from the request is written to the first response
+	// byte is received.
+	ServerProcessing time.Duration
+
+	// TimeToFirstByte is the duration from the request starts to the first response byte
+	// is received, including the phases above.
+	TimeToFirstByte time.Duration
+
+	// BodyRead is the duration from the response headers are received to the response
+	// body is read completely or closed. It is zero if the body is not read yet.
+	BodyRead time.Duration
+
+	// Total is the duration from the request starts to the response body is read
+	// completely or closed, or to the response headers are received if the body is not
+	// read yet.
+	Total time.Duration
+
+	// ConnReused indicates whether the connection was used for the previous requests.
+	ConnReused bool
+
+	// RemoteAddr is the remote address of the connection, it is empty if the request is
+	// not sent over a network connection.
+	RemoteAddr string
+}
+
+// The traceContextKey type is the context key of the request trace.
+type traceContextKey struct{}
+
+// The requestTrace type collects the timings of a single attempt of the request.
+type requestTrace struct {
+	mutex        sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wrote        time.Time
+	firstByte    time.Time
+	headers      time.Time
+	bodyDone     time.Time
+	reused       bool
+	remoteAddr   string
+}
+
+// ResponseTimings returns the timings of the request that produced the given response,
+// or nil if the timings are not captured (see Request.WithTimings and Client.SetTimings).
+// This function is useful for the custom responders, it should be called after the
+// response body is read, so that the body read duration is included.
+func ResponseTimings(o *http.Response) *Timings {
+	if o == nil || o.Request == nil {
+		return nil
+	}
+	if t, ok := o.Request.Context().Value(traceContextKey{}).(*requestTrace); ok {
+		return t.timings()
+	}
+	return nil
+}
+
+// The traceRequest function returns a copy of the given request that captures the
+// timings by the returned trace.
+func traceRequest(req *http.Request) (*http.Request, *requestTrace) {
+	t := &requestTrace{start: time.Now()}
+	ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GetConn:              func(string) { t.reset() },
+		DNSStart:             func(httptrace.DNSStartInfo) { t.set(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectStart:         func(string, string) { t.setOnce(&t.connectStart) },
+		ConnectDone:          t.connectDoneHook,
+		TLSHandshakeStart:    func() { t.set(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.set(&t.tlsDone) },
+		GotConn:              t.gotConnHook,
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.set(&t.wrote) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte) },
+	})
+	return req.WithContext(context.WithValue(ctx, traceContextKey{}, t)), t
+}
+
+// The reset method clears the connection timings, the redirects and the retries of the
+// transport get a new connection.
+func (t *requestTrace) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.dnsStart, t.dnsDone = time.Time{}, time.Time{}
+	t.connectStart, t.connectDone = time.Time{}, time.Time{}
+	t.tlsStart, t.tlsDone = time.Time{}, time.Time{}
+	t.wrote, t.firstByte = time.Time{}, time.Time{}
+	t.reused, t.remoteAddr = false, ""
+}
+
+// The set method records the current time to the given field.
+func (t *requestTrace) set(field *time.Time) {
+	t.mutex.Lock()
+	*field = time.Now()
+	t.mutex.Unlock()
+}
+
+// The setOnce method records the current time to the given field if it is not recorded.
+func (t *requestTrace) setOnce(field *time.Time) {
+	t.mutex.Lock()
+	if field.IsZero() {
+		*field = time.Now()
+	}
+	t.mutex.Unlock()
+}
+
+// The connectDoneHook method records the end of the successful connection.
+func (t *requestTrace) connectDoneHook(_, _ string, err error) {
+	if err == nil {
+		t.set(&t.connectDone)
+	}
+}
+
+// The gotConnHook method records the information of the connection.
+func (t *requestTrace) gotConnHook(info httptrace.GotConnInfo) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.reused = info.Reused
+	if info.Conn != nil && info.Conn.RemoteAddr() != nil {
+		t.remoteAddr = info.Conn.RemoteAddr().String()
+	}
+}
+
+// The wrap method records the end of the response headers, and wraps the response body
+// of the given response to record the end of the response body.
+func (t *requestTrace) wrap(o *http.Response) {
+	t.set(&t.headers)
+	if o.Body != nil {
+		o.Body = &traceBody{ReadCloser: o.Body, trace: t}
+	}
+}
+
+// The timings method returns the timings collected by the current trace.
+func (t *requestTrace) timings() *Timings {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	firstByte := t.firstByte
+	if firstByte.IsZero() {
+		// The transport does not report the first response byte.
+		firstByte = t.headers
+	}
+	end := t.bodyDone
+	if end.IsZero() {
+		end = t.headers
+	}
+	return &Timings{
+		DNS:              traceDuration(t.dnsStart, t.dnsDone),
+		Connect:          traceDuration(t.connectStart, t.connectDone),
+		TLSHandshake:     traceDuration(t.tlsStart, t.tlsDone),
+		ServerProcessing: traceDuration(t.wrote, firstByte),
+		TimeToFirstByte:  traceDuration(t.start, firstByte),
+		BodyRead:         traceDuration(t.headers, t.bodyDone),
+		Total:            traceDuration(t.start, end),
+		ConnReused:       t.reused,
+		RemoteAddr:       t.remoteAddr,
+	}
+}
+
+// The traceDuration function returns the duration between the given times, or zero if
+// either time is not recorded.
+func traceDuration(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// The traceBody type records the end of the response body.
+type traceBody struct {
+	io.ReadCloser
+	trace *requestTrace
+}
+
+// Read reads the response body, and records the end of the response body at io.EOF.
+func (b *traceBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.trace.setOnce(&b.trace.bodyDone)
+	}
+	return n, err
+}
+
+// Close closes the response body, and records the end of the response body.
+func (b *traceBody) Close() error {
+	b.trace.setOnce(&b.trace.bodyDone)
+	return b.ReadCloser.Close()
+}
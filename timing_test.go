@@ -0,0 +1,128 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequest_WithTimings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	// The localhost is resolved by the DNS lookup.
+	c := New().SetHTTPClient(&http.Client{Transport: &http.Transport{}}).
+		SetBaseURL(strings.Replace(server.URL, "127.0.0.1", "localhost", 1))
+
+	res, err := c.New("/").WithTimings().Get()
+	if err != nil {
+		t.Fatalf("Request.WithTimings(): %s", err)
+	}
+	timings := res.Timings()
+	if timings == nil {
+		t.Fatal("Request.WithTimings(): nil timings")
+	}
+	if timings.DNS <= 0 || timings.Connect <= 0 || timings.ConnReused || timings.RemoteAddr == "" {
+		t.Fatalf("Request.WithTimings(): %+v", timings)
+	}
+	if timings.ServerProcessing < 10*time.Millisecond || timings.TimeToFirstByte < timings.ServerProcessing {
+		t.Fatalf("Request.WithTimings(): %+v", timings)
+	}
+	if timings.Total < timings.TimeToFirstByte+timings.BodyRead || timings.TLSHandshake != 0 {
+		t.Fatalf("Request.WithTimings(): %+v", timings)
+	}
+
+	// The second request reuses the connection.
+	if res, err = c.New("/").WithTimings().Get(); err != nil {
+		t.Fatalf("Request.WithTimings(): %s", err)
+	}
+	if timings = res.Timings(); timings == nil || !timings.ConnReused || timings.DNS != 0 || timings.Connect != 0 {
+		t.Fatalf("Request.WithTimings(): %+v", timings)
+	}
+
+	// The timings are not captured by default.
+	if res, err = c.New("/").Get(); err != nil {
+		t.Fatalf("Request.WithTimings(): %s", err)
+	}
+	if res.Timings() != nil {
+		t.Fatalf("Request.WithTimings(): %+v", res.Timings())
+	}
+	if NewEmptyResponse().Timings() != nil || ResponseTimings(nil) != nil {
+		t.Fatal("Response.Timings(): non-nil timings")
+	}
+}
+
+func TestClient_SetTimings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	c := New().SetHTTPClient(server.Client()).SetBaseURL(server.URL).SetTimings(true)
+	res, err := c.New("/").Get()
+	if err != nil {
+		t.Fatalf("Client.SetTimings(): %s", err)
+	}
+	if timings := res.Timings(); timings == nil || timings.TLSHandshake <= 0 || timings.RemoteAddr != server.Listener.Addr().String() {
+		t.Fatalf("Client.SetTimings(): %+v", timings)
+	}
+
+	// The custom responders receive the timings by ResponseTimings.
+	var timings *Timings
+	res, err = c.New("/").WithResponder(func(o *http.Response, noBody bool) (Response, error) {
+		_, _ = ioutil.ReadAll(o.Body)
+		timings = ResponseTimings(o)
+		return NewEmptyResponse(), nil
+	}).Get()
+	if err != nil {
+		t.Fatalf("Client.SetTimings(): %s", err)
+	}
+	if timings == nil || !timings.ConnReused || timings.BodyRead <= 0 {
+		t.Fatalf("Client.SetTimings(): %+v", timings)
+	}
+
+	if res, err = c.SetTimings(false).New("/").Get(); err != nil {
+		t.Fatalf("Client.SetTimings(): %s", err)
+	}
+	if res.Timings() != nil {
+		t.Fatalf("Client.SetTimings(): %+v", res.Timings())
+	}
+}
+
+func TestClient_SetTimings_Handler(t *testing.T) {
+	c := New().SetTimings(true).SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	res, err := c.New("/").Get()
+	if err != nil {
+		t.Fatalf("Client.SetTimings(): %s", err)
+	}
+	// The handler transport opens no connection, the time to first byte is measured until
+	// the response headers are received.
+	timings := res.Timings()
+	if timings == nil || timings.Connect != 0 || timings.RemoteAddr != "" || timings.TimeToFirstByte < 10*time.Millisecond {
+		t.Fatalf("Client.SetTimings(): %+v", timings)
+	}
+}
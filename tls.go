@@ -0,0 +1,192 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ErrInvalidTLSOptions is returned when the TLS options are invalid.
+var ErrInvalidTLSOptions = errors.New("invalid tls options")
+
+// ErrPinMismatch is the error wrapped by PinMismatchError, it can be used with errors.Is.
+var ErrPinMismatch = errors.New("certificate pin mismatch")
+
+// PinMismatchError is returned when no certificate presented by the server matches the
+// public key pins of the TLS options.
+type PinMismatchError struct {
+	// ServerName is the server name of the TLS connection, it is empty if the server
+	// is addressed by the IP address.
+	ServerName string
+
+	// Pins is the SPKI SHA-256 pins of the certificates presented by the server,
+	// in the "sha256/<base64>" form.
+	Pins []string
+}
+
+// Error returns the error message.
+func (e *PinMismatchError) Error() string {
+	if e.ServerName == "" {
+		return fmt.Sprintf("tls: %s: got %s", ErrPinMismatch, strings.Join(e.Pins, ", "))
+	}
+	return fmt.Sprintf("tls: %s for %s: got %s", ErrPinMismatch, e.ServerName, strings.Join(e.Pins, ", "))
+}
+
+// Unwrap returns ErrPinMismatch.
+func (e *PinMismatchError) Unwrap() error {
+	return ErrPinMismatch
+}
+
+// TLSKeyPair defines the PEM encoded certificate and private key files.
+type TLSKeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// TLSOptions defines the TLS configuration of the client.
+type TLSOptions struct {
+	// RootCAFiles is the PEM encoded CA certificate files used to verify the server.
+	// If it is empty, the system root CAs are used.
+	RootCAFiles []string
+
+	// WithSystemRoots adds the system root CAs to the CAs of RootCAFiles.
+	WithSystemRoots bool
+
+	// ClientCertificates is the client certificates presented to the server for the
+	// mutual TLS authentication.
+	ClientCertificates []TLSKeyPair
+
+	// ClientCertificateReloader is the source of the client certificate that is reloaded
+	// when the files are modified, see NewCertificateReloader.
+	// If it is not nil, ClientCertificates is ignored.
+	ClientCertificateReloader CertificateReloader
+
+	// MinVersion is the minimum TLS version, such as tls.VersionTLS12.
+	// If it is zero, TLS 1.2 is used.
+	MinVersion uint16
+
+	// ServerName overrides the server name used to verify the server certificate and
+	// sent in the SNI extension.
+	ServerName string
+
+	// PinnedPublicKeys is the set of SPKI SHA-256 pins in the "sha256/<base64>" form (the
+	// "sha256/" prefix is optional). If it is not empty, the TLS connection is rejected
+	// with a PinMismatchError unless a certificate of the verified chain matches a pin.
+	PinnedPublicKeys []string
+}
+
+// NewTLSConfig creates a TLS configuration from the given options.
+// The returned configuration can be used by Client.SetTLSConfig.
+func NewTLSConfig(options *TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: options.ServerName,
+		MinVersion: options.MinVersion,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if len(options.RootCAFiles) > 0 {
+		pool, err := loadCertPool(options.RootCAFiles, options.WithSystemRoots)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if options.ClientCertificateReloader != nil {
+		config.GetClientCertificate = options.ClientCertificateReloader.GetClientCertificate
+	} else {
+		for _, pair := range options.ClientCertificates {
+			cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidTLSOptions, err)
+			}
+			config.Certificates = append(config.Certificates, cert)
+		}
+	}
+
+	if len(options.PinnedPublicKeys) > 0 {
+		pins := make(map[string]bool, len(options.PinnedPublicKeys))
+		for _, pin := range options.PinnedPublicKeys {
+			s := strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+			if b, err := base64.StdEncoding.DecodeString(s); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("%w: invalid public key pin %q", ErrInvalidTLSOptions, pin)
+			}
+			pins[s] = true
+		}
+		config.VerifyConnection = verifyPinnedPublicKeys(pins)
+	}
+	return config, nil
+}
+
+// The loadCertPool function loads the PEM encoded certificates from the given files.
+func loadCertPool(files []string, system bool) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if system {
+		var err error
+		if pool, err = x509.SystemCertPool(); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTLSOptions, err)
+		}
+	}
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTLSOptions, err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%w: no certificate found in %s", ErrInvalidTLSOptions, name)
+		}
+	}
+	return pool, nil
+}
+
+// PublicKeyPin returns the SPKI SHA-256 pin of the given certificate in the
+// "sha256/<base64>" form.
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// The verifyPinnedPublicKeys function returns the connection verifier that checks the
+// certificates of the connection against the given pins.
+func verifyPinnedPublicKeys(pins map[string]bool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		// The verified chains are empty only if the certificate verification is skipped.
+		var certs []*x509.Certificate
+		for _, chain := range cs.VerifiedChains {
+			certs = append(certs, chain...)
+		}
+		if len(certs) == 0 {
+			certs = cs.PeerCertificates
+		}
+		got := make([]string, 0, len(certs))
+		for _, cert := range certs {
+			pin := PublicKeyPin(cert)
+			if pins[pin[len("sha256/"):]] {
+				return nil
+			}
+			got = append(got, pin)
+		}
+		return &PinMismatchError{ServerName: cs.ServerName, Pins: got}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair tls.Certificate
+	pem  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, client bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey(): %s", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := template, key
+	switch {
+	case parent == nil:
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	case client:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		signer, signerKey = parent.cert, parent.key
+	default:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = []string{name}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate(): %s", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("tls.X509KeyPair(): %s", err)
+	}
+	return &testCert{cert: cert, key: key, pair: pair, pem: append(certPEM, keyPEM...)}
+}
+
+// The writeFiles method writes the certificate and the private key to the given directory.
+func (c *testCert) writeFiles(t *testing.T, dir, name string) TLSKeyPair {
+	pair := TLSKeyPair{CertFile: filepath.Join(dir, name+".crt"), KeyFile: filepath.Join(dir, name+".key")}
+	block, rest := pem.Decode(c.pem)
+	if err := ioutil.WriteFile(pair.CertFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile(): %s", err)
+	}
+	if err := ioutil.WriteFile(pair.KeyFile, rest, 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile(): %s", err)
+	}
+	return pair
+}
+
+func newTestTLSServer(t *testing.T, ca, server *testCert) *httptest.Server {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.TLS = &tls.Config{
+		Certificates: []tls.Certificate{server.pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	s.StartTLS()
+	return s
+}
+
+func newTestTempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "requester")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(): %s", err)
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, clean := newTestTempDir(t)
+	defer clean()
+
+	ca := newTestCert(t, "ca", nil, false)
+	server := newTestCert(t, "server.test", ca, false)
+	client := newTestCert(t, "client", ca, true)
+	caFiles := ca.writeFiles(t, dir, "ca")
+	clientFiles := client.writeFiles(t, dir, "client")
+
+	s := newTestTLSServer(t, ca, server)
+	defer s.Close()
+
+	config, err := NewTLSConfig(&TLSOptions{
+		RootCAFiles:        []string{caFiles.CertFile},
+		ClientCertificates: []TLSKeyPair{clientFiles},
+		ServerName:         "server.test",
+		PinnedPublicKeys:   []string{PublicKeyPin(ca.cert)},
+	})
+	if err != nil {
+		t.Fatalf("NewTLSConfig(): %s", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("NewTLSConfig(): MinVersion %x", config.MinVersion)
+	}
+
+	c := New().SetTLSConfig(config)
+	res, err := c.New(s.URL).Get()
+	if err != nil {
+		t.Fatalf("Client.SetTLSConfig(): %s", err)
+	}
+	if got := res.String(); got != "client" {
+		t.Fatalf("Client.SetTLSConfig(): %s", got)
+	}
+
+	// The server rejects the connection without the client certificate.
+	config, err = NewTLSConfig(&TLSOptions{RootCAFiles: []string{caFiles.CertFile}, WithSystemRoots: true})
+	if err != nil {
+		t.Fatalf("NewTLSConfig(): %s", err)
+	}
+	if _, err := c.SetTLSConfig(config).New(s.URL).Get(); err == nil {
+		t.Fatal("Client.SetTLSConfig(): nil error")
+	}
+
+	// The server certificate is not trusted.
+	if _, err := c.SetTLSConfig(nil).New(s.URL).Get(); err == nil {
+		t.Fatal("Client.SetTLSConfig(): nil error")
+	}
+}
+
+func TestNewTLSConfig_PinMismatch(t *testing.T) {
+	dir, clean := newTestTempDir(t)
+	defer clean()
+
+	ca := newTestCert(t, "ca", nil, false)
+	server := newTestCert(t, "server.test", ca, false)
+	client := newTestCert(t, "client", ca, true)
+	other := newTestCert(t, "other", nil, false)
+	caFiles := ca.writeFiles(t, dir, "ca")
+
+	s := newTestTLSServer(t, ca, server)
+	defer s.Close()
+
+	config, err := NewTLSConfig(&TLSOptions{
+		RootCAFiles:      []string{caFiles.CertFile},
+		PinnedPublicKeys: []string{PublicKeyPin(other.cert)},
+	})
+	if err != nil {
+		t.Fatalf("NewTLSConfig(): %s", err)
+	}
+	config.Certificates = []tls.Certificate{client.pair}
+
+	_, err = New().SetTLSConfig(config).New(s.URL).Get()
+	if !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("NewTLSConfig(): %v", err)
+	}
+	var e *PinMismatchError
+	if !errors.As(err, &e) {
+		t.Fatalf("NewTLSConfig(): %T", err)
+	}
+	if e.ServerName != "" || len(e.Pins) != 2 || e.Pins[0] != PublicKeyPin(server.cert) || e.Pins[1] != PublicKeyPin(ca.cert) {
+		t.Fatalf("NewTLSConfig(): %+v", e)
+	}
+}
+
+func TestNewTLSConfig_Invalid(t *testing.T) {
+	dir, clean := newTestTempDir(t)
+	defer clean()
+
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, []byte("empty"), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile(): %s", err)
+	}
+	items := []*TLSOptions{
+		{RootCAFiles: []string{filepath.Join(dir, "missing.pem")}},
+		{RootCAFiles: []string{empty}},
+		{ClientCertificates: []TLSKeyPair{{CertFile: empty, KeyFile: empty}}},
+		{PinnedPublicKeys: []string{"sha256/invalid"}},
+		{PinnedPublicKeys: []string{"c2hvcnQ="}},
+	}
+	for i, item := range items {
+		if _, err := NewTLSConfig(item); !errors.Is(err, ErrInvalidTLSOptions) {
+			t.Fatalf("NewTLSConfig(): %d %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/tls"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/edoger/zkits-requester/internal"
+)
+
+// Default values of the dialer fields of the TransportOptions, they are the same as the
+// built-in default HTTP client. The other default values are taken from the transport
+// of the built-in default HTTP client.
+const (
+	DefaultDialTimeout = 30 * time.Second
+	DefaultKeepAlive   = 30 * time.Second
+)
+
+// TransportOptions defines the transport of the HTTP client.
+// The zero value of each field means the value of the transport of the built-in default
+// HTTP client, and the negative value of the numeric fields means no limit (or disabled
+// for the timeouts).
+type TransportOptions struct {
+	// MaxIdleConns is the maximum number of idle connections across all hosts.
+	// If it is zero, 100 is used.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections per host.
+	// If it is zero, 10 is used.
+	MaxIdleConnsPerHost int
+
+	// MaxConnsPerHost is the maximum number of connections per host, including the
+	// connections in the dialing, active and idle states. If it is zero, there is no limit.
+	MaxConnsPerHost int
+
+	// IdleConnTimeout is the maximum amount of time an idle connection remains idle.
+	// If it is zero, 90 seconds is used.
+	IdleConnTimeout time.Duration
+
+	// DialTimeout is the maximum amount of time a dial waits for a connect to complete.
+	// If it is zero, DefaultDialTimeout is used.
+	DialTimeout time.Duration
+
+	// KeepAlive is the interval of the TCP keep-alive probes.
+	// If it is zero, DefaultKeepAlive is used.
+	KeepAlive time.Duration
+
+	// DisableKeepAlives disables the HTTP keep-alives, each connection is used for
+	// a single request.
+	DisableKeepAlives bool
+
+	// TLSHandshakeTimeout is the maximum amount of time waiting for a TLS handshake.
+	// If it is zero, 10 seconds is used.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout is the maximum amount of time waiting for the response headers
+	// after the request is fully written. If it is zero, there is no timeout.
+	ResponseHeaderTimeout time.Duration
+
+	// ExpectContinueTimeout is the maximum amount of time waiting for the first response
+	// headers after the request headers are written, if the request has an
+	// "Expect: 100-continue" header. If it is zero, 1 second is used.
+	ExpectContinueTimeout time.Duration
+
+	// DisableHTTP2 disables the HTTP/2 protocol.
+	DisableHTTP2 bool
+
+	// MaxResponseHeaderBytes is the limit of the response headers size.
+	// If it is zero, the default limit of the http.Transport (10MB) is used.
+	MaxResponseHeaderBytes int64
+
+	// DisableCompression disables the transparent gzip compression of the responses.
+	DisableCompression bool
+}
+
+// NewTransport creates an HTTP transport from the given options.
+// If nil is given, the default options are used, which produce the same transport as the
+// built-in default HTTP client.
+func NewTransport(options *TransportOptions) *http.Transport {
+	t := internal.NewTransport()
+	if options == nil {
+		return t
+	}
+	if options.DialTimeout != 0 || options.KeepAlive != 0 {
+		dialer := &net.Dialer{
+			Timeout:   transportDuration(options.DialTimeout, DefaultDialTimeout),
+			KeepAlive: transportDuration(options.KeepAlive, DefaultKeepAlive),
+		}
+		if options.KeepAlive < 0 {
+			// The negative value disables the TCP keep-alive probes.
+			dialer.KeepAlive = -1
+		}
+		t.DialContext = dialer.DialContext
+	}
+	t.MaxIdleConns = transportLimit(options.MaxIdleConns, t.MaxIdleConns)
+	t.MaxIdleConnsPerHost = transportLimit(options.MaxIdleConnsPerHost, t.MaxIdleConnsPerHost)
+	t.MaxConnsPerHost = transportLimit(options.MaxConnsPerHost, t.MaxConnsPerHost)
+	t.IdleConnTimeout = transportDuration(options.IdleConnTimeout, t.IdleConnTimeout)
+	t.TLSHandshakeTimeout = transportDuration(options.TLSHandshakeTimeout, t.TLSHandshakeTimeout)
+	t.ResponseHeaderTimeout = transportDuration(options.ResponseHeaderTimeout, t.ResponseHeaderTimeout)
+	t.ExpectContinueTimeout = transportDuration(options.ExpectContinueTimeout, t.ExpectContinueTimeout)
+	t.DisableKeepAlives = options.DisableKeepAlives
+	t.DisableCompression = options.DisableCompression
+
+	switch {
+	case options.MaxResponseHeaderBytes > 0:
+		t.MaxResponseHeaderBytes = options.MaxResponseHeaderBytes
+	case options.MaxResponseHeaderBytes < 0:
+		// The zero value of http.Transport.MaxResponseHeaderBytes means 10MB, use the
+		// maximum value instead.
+		t.MaxResponseHeaderBytes = math.MaxInt64
+	}
+	if options.MaxIdleConnsPerHost < 0 {
+		// The zero value of http.Transport.MaxIdleConnsPerHost means 2, use the maximum
+		// number of the idle connections instead.
+		t.MaxIdleConnsPerHost = t.MaxIdleConns
+		if t.MaxIdleConnsPerHost == 0 {
+			t.MaxIdleConnsPerHost = math.MaxInt32
+		}
+	}
+	if options.DisableHTTP2 {
+		// A non-nil empty map disables the HTTP/2 protocol.
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return t
+}
+
+// NewHTTPClient creates an HTTP client with the transport created from the given options.
+// The returned HTTP client can be used by Client.SetHTTPClient.
+// If nil is given, the default options are used.
+func NewHTTPClient(options *TransportOptions) *http.Client {
+	return &http.Client{Transport: NewTransport(options)}
+}
+
+// The transportLimit function returns the limit of the transport, the zero value means
+// the given default value and the negative value means no limit.
+func transportLimit(n, def int) int {
+	switch {
+	case n == 0:
+		return def
+	case n < 0:
+		return 0
+	}
+	return n
+}
+
+// The transportDuration function returns the timeout of the transport, the zero value
+// means the given default value and the negative value means no timeout.
+func transportDuration(d, def time.Duration) time.Duration {
+	switch {
+	case d == 0:
+		return def
+	case d < 0:
+		return 0
+	}
+	return d
+}
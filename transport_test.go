@@ -0,0 +1,99 @@
+// Copyright 2021 The ZKits Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edoger/zkits-requester/internal"
+)
+
+func TestNewTransport(t *testing.T) {
+	got, want := NewTransport(nil), internal.NewTransport()
+	if got.MaxIdleConns != want.MaxIdleConns || got.MaxIdleConnsPerHost != want.MaxIdleConnsPerHost ||
+		got.IdleConnTimeout != want.IdleConnTimeout || got.TLSHandshakeTimeout != want.TLSHandshakeTimeout ||
+		got.ExpectContinueTimeout != want.ExpectContinueTimeout || got.ForceAttemptHTTP2 != want.ForceAttemptHTTP2 ||
+		got.Proxy == nil || got.DialContext == nil {
+		t.Fatalf("NewTransport(): %+v", got)
+	}
+
+	tr := NewTransport(&TransportOptions{
+		MaxIdleConns:           -1,
+		MaxIdleConnsPerHost:    -1,
+		MaxConnsPerHost:        5,
+		IdleConnTimeout:        -1,
+		DialTimeout:            time.Second,
+		KeepAlive:              -1,
+		DisableKeepAlives:      true,
+		TLSHandshakeTimeout:    2 * time.Second,
+		ResponseHeaderTimeout:  3 * time.Second,
+		ExpectContinueTimeout:  -1,
+		DisableHTTP2:           true,
+		MaxResponseHeaderBytes: 1024,
+		DisableCompression:     true,
+	})
+	if tr.MaxIdleConns != 0 || tr.MaxIdleConnsPerHost <= want.MaxIdleConnsPerHost || tr.MaxConnsPerHost != 5 ||
+		tr.IdleConnTimeout != 0 || !tr.DisableKeepAlives || tr.TLSHandshakeTimeout != 2*time.Second ||
+		tr.ResponseHeaderTimeout != 3*time.Second || tr.ExpectContinueTimeout != 0 || tr.ForceAttemptHTTP2 ||
+		tr.TLSNextProto == nil || len(tr.TLSNextProto) != 0 || tr.MaxResponseHeaderBytes != 1024 || !tr.DisableCompression {
+		t.Fatalf("NewTransport(): %+v", tr)
+	}
+
+	if tr = NewTransport(&TransportOptions{MaxIdleConns: 20, MaxIdleConnsPerHost: -1}); tr.MaxIdleConnsPerHost != 20 {
+		t.Fatalf("NewTransport(): MaxIdleConnsPerHost %d", tr.MaxIdleConnsPerHost)
+	}
+	if tr = NewTransport(&TransportOptions{MaxResponseHeaderBytes: -1}); tr.MaxResponseHeaderBytes != math.MaxInt64 {
+		t.Fatalf("NewTransport(): MaxResponseHeaderBytes %d", tr.MaxResponseHeaderBytes)
+	}
+	if tr = NewTransport(&TransportOptions{}); tr.MaxIdleConns != want.MaxIdleConns || tr.MaxResponseHeaderBytes != 0 {
+		t.Fatalf("NewTransport(): %+v", tr)
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.Header().Set("X-Large", strings.Repeat("x", 4096))
+		}
+		_, _ = io.WriteString(w, r.Header.Get("Accept-Encoding"))
+	}))
+	defer server.Close()
+
+	c := New().SetHTTPClient(NewHTTPClient(nil))
+	res, err := c.New(server.URL).Get()
+	if err != nil {
+		t.Fatalf("NewHTTPClient(): %s", err)
+	}
+	if got := res.String(); got != "gzip" {
+		t.Fatalf("NewHTTPClient(): %s", got)
+	}
+
+	c.SetHTTPClient(NewHTTPClient(&TransportOptions{DisableCompression: true, MaxResponseHeaderBytes: 1024}))
+	if res, err = c.New(server.URL).Get(); err != nil {
+		t.Fatalf("NewHTTPClient(): %s", err)
+	}
+	if got := res.String(); got != "" {
+		t.Fatalf("NewHTTPClient(): %s", got)
+	}
+	if _, err = c.New(server.URL + "/large").Get(); err == nil {
+		t.Fatal("NewHTTPClient(): nil error")
+	}
+}